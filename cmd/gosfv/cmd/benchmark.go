@@ -0,0 +1,96 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lobbin/gosfv/internal/sfv"
+	"github.com/spf13/cobra"
+)
+
+// benchmarkCmd represents the benchmark command
+var benchmarkCmd = &cobra.Command{
+	Use:   "benchmark [flags] [file]",
+	Short: "Measure hashing throughput of each algorithm",
+	Long: `Measure hashing throughput of each algorithm.
+
+Quick fingerprints aren't measured, they skip the middle of big files instead
+of hashing it, so their throughput isn't comparable.`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		duration, err := cmd.Flags().GetDuration("duration")
+		if err != nil {
+			return err
+		}
+
+		var buf []byte
+		if len(args) > 0 {
+			buf, err = ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+		} else {
+			size, err := cmd.Flags().GetInt("size")
+			if err != nil {
+				return err
+			}
+
+			buf = make([]byte, size)
+			rand.Read(buf)
+		}
+
+		if len(buf) == 0 {
+			return errors.New("Nothing to hash")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "Algorithm\tThroughput")
+		for _, result := range sfv.Benchmark(buf, duration) {
+			fmt.Fprintf(w, "%s\t%.1f MB/s\n", sfv.TypeToString(result.ChecksumType), result.Throughput())
+		}
+
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(benchmarkCmd)
+
+	benchmarkCmd.Flags().Duration("duration", time.Second, "Time spent hashing with each algorithm")
+	benchmarkCmd.Flags().Int("size", 1024*1024, "Size of the synthetic buffer in bytes")
+}
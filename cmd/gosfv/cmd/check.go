@@ -0,0 +1,115 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/lobbin/gosfv/internal/sfv"
+	"github.com/spf13/cobra"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check [flags] [checksum:file]...",
+	Short: "Verify files against checksums given on the command line",
+	Long: `Verify files against checksums given on the command line, without a
+verification file. The algorithm is taken from the length of each checksum,
+unless --type is given.
+
+Subresource Integrity strings, like sha384-<base64>:script.js, are accepted
+as checksums too.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		pairs, _ := cmd.Flags().GetStringArray("pairs")
+		if len(pairs)+len(args) < 1 {
+			return errors.New("Need at least one checksum:file pair")
+		}
+
+		if cmd.Flags().Changed("type") {
+			typeValue := cmd.Flag("type").Value.String()
+			if sfv.StringToType(typeValue) == sfv.TypeUnknown {
+				return fmt.Errorf("Unknown algorithm: %s", typeValue)
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		setupColor("auto")
+		options.Stop = stopOnSignal()
+
+		checksumType := sfv.TypeUnknown
+		if cmd.Flags().Changed("type") {
+			checksumType = sfv.StringToType(cmd.Flag("type").Value.String())
+		}
+
+		pairs, _ := cmd.Flags().GetStringArray("pairs")
+		checksumFiles := make([]sfv.ChecksumFile, 0, len(pairs)+len(args))
+		for _, pair := range append(pairs, args...) {
+			checksumFile, err := sfv.ParsePair(pair, checksumType)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+
+			checksumFiles = append(checksumFiles, checksumFile)
+		}
+
+		start := time.Now()
+		checksumFiles = sfv.VerifyEntriesWithOptions(checksumFiles, options)
+
+		error := false
+		for _, checksumFile := range checksumFiles {
+			status := statusColor(checksumFile.Status).Sprint(sfv.StatusTypeToString(checksumFile.Status))
+			fmt.Fprintf(color.Output, "%s %s\n", displayName(checksumFile), status)
+
+			if checksumFile.Status != sfv.StatusCheckSumOK {
+				error = true
+			}
+		}
+
+		printSummary(os.Stdout, "Verified", checksumFiles, start)
+
+		if error {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().StringArray("pairs", []string{}, "A checksum:file pair to verify, may be repeated")
+}
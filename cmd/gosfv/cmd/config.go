@@ -0,0 +1,103 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configured holds the flags set from the config file
+var configured = make(map[*pflag.Flag]bool)
+
+// applyConfig sets the flags of cmd and its subcommands which weren't given
+// on the command line from the config file, so the command line wins. Keys
+// are named like the flags, either at the top, applying to every command
+// with the flag, or under a section named like the command:
+//
+//   type: sha256
+//   jobs: 8
+//   verify:
+//     color: never
+//
+// Flags set from the config file don't count as changed, so conflicts
+// between flags are only checked for those given on the command line, but
+// are recorded for isSet.
+func applyConfig(cmd *cobra.Command) error {
+	// The command run shares the flags of its parents, which must be set
+	// from its section first
+	for _, subCmd := range cmd.Commands() {
+		if err := applyConfig(subCmd); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	flags := cmd.Flags()
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || configured[f] || f.Name == "config" || f.Name == "help" || f.Name == "version" {
+			return
+		}
+
+		key := cmd.Name() + "." + f.Name
+		if !viper.IsSet(key) {
+			key = f.Name
+		}
+		if !viper.IsSet(key) {
+			return
+		}
+
+		// Setting a slice appends to what was set before, so it's replaced
+		// at once instead
+		if value, ok := f.Value.(pflag.SliceValue); ok {
+			err = value.Replace(viper.GetStringSlice(key))
+		} else {
+			err = f.Value.Set(viper.GetString(key))
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %s", key, err)
+			return
+		}
+		configured[f] = true
+	})
+
+	return err
+}
+
+// isSet tells whether the flag name of cmd was given, on the command line or
+// in the config file
+func isSet(cmd *cobra.Command, name string) bool {
+	f := cmd.Flags().Lookup(name)
+	return f != nil && (f.Changed || configured[f])
+}
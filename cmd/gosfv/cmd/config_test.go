@@ -0,0 +1,74 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".gosfv.yaml": "type: md5\ncreate:\n  no-header: true\n",
+		"hello.txt":   "hello\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"create", "hello.txt"}, 0, "MD5 (hello.txt) = b1946ac92492d2347c6235b4d2611184"},
+		// The command line wins
+		{[]string{"create", "-t", "sha1", "hello.txt"}, 0, "f572d396fae9206628714fb2ce00f72e94f2258f  hello.txt"},
+		// Flags from the config don't conflict with the command line
+		{[]string{"create", "--quick", "hello.txt"}, 0, "hello.txt"},
+		{[]string{"create", "--quick", "-t", "sha1", "hello.txt"}, 1, "Options --quick and --type can't be combined"},
+	}
+
+	for _, test := range tests {
+		code, output := runGosfv(t, dir, test.args...)
+		if code != test.code || !strings.Contains(output, test.want) {
+			t.Errorf("%v: exit code %d, output %q, want %d and %q", test.args, code, output, test.code, test.want)
+		}
+		if code == 0 && strings.Contains(output, "; Generated by") {
+			t.Errorf("%v: header written despite the create section", test.args)
+		}
+	}
+}
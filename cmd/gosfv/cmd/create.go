@@ -33,6 +33,10 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/lobbin/gosfv/internal/sfv"
 	"github.com/spf13/cobra"
@@ -41,28 +45,318 @@ import (
 // createCmd represents the create command
 var createCmd = &cobra.Command{
 	Use:   "create [flags] [files]",
+	Long: `Generate a new verification file for the given files.
+
+A file named - reads standard input, which is written as --stdin-name.
+
+With --recursive the files under the directories given are hashed. A
+directory given which is a symlink is followed, symlinks to directories
+inside it aren't.
+
+Several algorithms separated by commas, like --type md5,sha1,sha256, are
+hashed in a single read of each file with --output-pattern naming a file per
+algorithm, where {alg} is replaced by the algorithm like in {alg}SUMS.
+
+With --quick files are only fingerprinted by their size and their first and
+last 4 MiB, written as QUICK entries. It's fast for huge files, but changes
+in the middle of a file go unnoticed, so it's no replacement for a hash.`,
 	Short: "Generate a new verfication file",
 	Args: func(cmd *cobra.Command, args []string) error {
-		if len(args) < 1 {
-			return errors.New("Need at least one file argument")
+		if len(args) < 1 && cmd.Flag("files-from").Value.String() == "" {
+			return errors.New("Need at least one file argument or --files-from")
 		}
 
-		typeValue := cmd.Flag("type").Value.String()
-		checksumType := sfv.StringToType(typeValue)
-		if checksumType == sfv.TypeUnknown {
-			return fmt.Errorf("Unknown algorithm: %s", typeValue)
+		checksumTypes, err := parseTypes(cmd.Flag("type").Value.String())
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flag("quick").Value.String() == "true" && cmd.Flags().Changed("type") {
+			return errors.New("Options --quick and --type can't be combined")
+		}
+
+		outputPattern := cmd.Flag("output-pattern").Value.String()
+		if len(checksumTypes) > 1 && outputPattern == "" {
+			return errors.New("Several algorithms need --output-pattern")
+		}
+
+		if outputPattern != "" {
+			if !strings.Contains(outputPattern, "{alg}") {
+				return errors.New("Option --output-pattern needs {alg} in the pattern")
+			}
+
+			if cmd.Flag("file").Value.String() != "" || cmd.Flag("auto-name").Value.String() == "true" ||
+				cmd.Flag("stream").Value.String() == "true" || cmd.Flag("split-per-dir").Value.String() == "true" ||
+				cmd.Flag("sidecar").Value.String() == "true" || cmd.Flag("join").Value.String() != "" {
+				return errors.New("Option --output-pattern can't be combined with --file, --auto-name, --stream, --split-per-dir, --sidecar or --join")
+			}
+		}
+
+		if _, err := sfv.ParseLineFormat(cmd.Flag("format").Value.String()); err != nil {
+			return fmt.Errorf("Invalid format: %s", err)
+		}
+
+		for _, checksumType := range checksumTypes {
+			if checksumType == sfv.TypeHMACSHA256 &&
+				cmd.Flag("hmac-key").Value.String() == "" &&
+				cmd.Flag("hmac-key-file").Value.String() == "" {
+				return errors.New("Algorithm hmac-sha256 needs --hmac-key or --hmac-key-file")
+			}
+
+			if cmd.Flag("format").Value.String() == "sri" && !sfv.IsIntegrityType(checksumType) {
+				return fmt.Errorf("Format sri needs algorithm sha256, sha384 or sha512, not %s", sfv.TypeToString(checksumType))
+			}
+		}
+
+		if _, err := parsePathStyle(cmd.Flag("path-style").Value.String()); err != nil {
+			return err
+		}
+
+		if _, err := parseSince(cmd.Flag("since").Value.String()); err != nil {
+			return err
+		}
+
+		if cmd.Flag("append").Value.String() == "true" &&
+			cmd.Flag("file").Value.String() == "" &&
+			cmd.Flag("auto-name").Value.String() != "true" {
+			return errors.New("Option --append needs --file or --auto-name")
+		}
+
+		if cmd.Flag("stream").Value.String() == "true" && cmd.Flag("split-per-dir").Value.String() == "true" {
+			return errors.New("Options --stream and --split-per-dir can't be combined")
+		}
+
+		if cmd.Flag("sidecar").Value.String() == "true" {
+			if cmd.Flag("file").Value.String() != "" || cmd.Flag("auto-name").Value.String() == "true" ||
+				cmd.Flag("stream").Value.String() == "true" || cmd.Flag("split-per-dir").Value.String() == "true" {
+				return errors.New("Option --sidecar can't be combined with --file, --auto-name, --stream or --split-per-dir")
+			}
+
+			for _, arg := range args {
+				if arg == "-" {
+					return errors.New("Option --sidecar can't be used with standard input")
+				}
+			}
+		}
+
+		if cmd.Flag("join").Value.String() != "" {
+			if cmd.Flag("stream").Value.String() == "true" || cmd.Flag("sidecar").Value.String() == "true" ||
+				cmd.Flag("recursive").Value.String() == "true" {
+				return errors.New("Option --join can't be combined with --stream, --sidecar or --recursive")
+			}
+
+			for _, arg := range args {
+				if arg == "-" {
+					return errors.New("Option --join can't be used with standard input")
+				}
+			}
+		}
+
+		if cmd.Flag("split-per-dir").Value.String() == "true" &&
+			cmd.Flag("file").Value.String() == "" &&
+			cmd.Flag("auto-name").Value.String() != "true" {
+			return errors.New("Option --split-per-dir needs --file or --auto-name")
 		}
 
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		checksumType := sfv.StringToType(cmd.Flag("type").Value.String())
-		checksumFiles := sfv.Create(checksumType, args)
+		checksumTypes, _ := parseTypes(cmd.Flag("type").Value.String())
+		if quick, _ := cmd.Flags().GetBool("quick"); quick {
+			checksumTypes = []sfv.ChecksumType{sfv.TypeQuick}
+		}
+		checksumType := checksumTypes[0]
+		noHeader, _ := cmd.Flags().GetBool("no-header")
+		options.WriteHeader = !noHeader
 
-		sfv.WriteToFile(checksumFiles, cmd.Flag("file").Value.String())
+		noTimestamp, _ := cmd.Flags().GetBool("no-timestamp")
+		if noTimestamp {
+			options.HeaderTimeFormat = ""
+		} else {
+			options.HeaderTimeFormat = cmd.Flag("time-format").Value.String()
+		}
+		options.HeaderLocalTime, _ = cmd.Flags().GetBool("local-time")
+		options.WritePathHashes, _ = cmd.Flags().GetBool("path-hashes")
+		options.WriteMetadata, _ = cmd.Flags().GetBool("metadata")
+		options.WriteXattrs, _ = cmd.Flags().GetBool("xattrs")
+		options.WriteManifestHash, _ = cmd.Flags().GetBool("manifest-hash")
+		options.StdinName = cmd.Flag("stdin-name").Value.String()
+		options.PathStyle, _ = parsePathStyle(cmd.Flag("path-style").Value.String())
+		options.RelativeTo = cmd.Flag("relative-to").Value.String()
+		if options.RelativeTo != "" && !isSet(cmd, "path-style") {
+			options.PathStyle = sfv.PathRelative
+		}
+		options.Append, _ = cmd.Flags().GetBool("append")
+		options.Stop = stopOnSignal()
+		options.Since, _ = parseSince(cmd.Flag("since").Value.String())
+		options.Recursive, _ = cmd.Flags().GetBool("recursive")
+
+		if format := cmd.Flag("format").Value.String(); format != "" {
+			options.LineTemplate, _ = sfv.ParseLineFormat(format)
+		}
+
+		files := args
+		if filesFrom := cmd.Flag("files-from").Value.String(); filesFrom != "" {
+			files = append(files, sfv.ReadFileList(filesFrom)...)
+		}
+
+		filename := cmd.Flag("file").Value.String()
+		if autoName, _ := cmd.Flags().GetBool("auto-name"); autoName && filename == "" {
+			filename = sfv.DefaultManifestName(checksumType)
+		}
+
+		sortChecksums, _ := cmd.Flags().GetBool("sort-checksums")
+
+		start := time.Now()
+		var checksumFiles []sfv.ChecksumFile
+		if outputPattern := cmd.Flag("output-pattern").Value.String(); outputPattern != "" {
+			typed := sfv.CreateTypesWithOptions(checksumTypes, files, options)
+			for i, t := range checksumTypes {
+				if sortChecksums {
+					sortByChecksum(typed[i])
+				}
+
+				name := strings.Replace(outputPattern, "{alg}", strings.ToUpper(sfv.TypeToString(t)), -1)
+				sfv.WriteToFileWithOptions(typed[i], name, options)
+			}
+
+			// The entries of each algorithm are of the same files
+			checksumFiles = typed[0]
+		} else if stream, _ := cmd.Flags().GetBool("stream"); stream && !sortChecksums {
+			checksumFiles = sfv.CreateToFileWithOptions(checksumType, files, filename, options)
+		} else {
+			if join := cmd.Flag("join").Value.String(); join != "" {
+				checksumFiles = sfv.CreateJoinedWithOptions(checksumType, join, files, options)
+			} else {
+				checksumFiles = sfv.CreateWithOptions(checksumType, files, options)
+			}
+
+			// Sorted entries can only be written once all are hashed, so
+			// they're buffered with --stream too
+			if sortChecksums {
+				sortByChecksum(checksumFiles)
+			}
+
+			if cmd.Flag("split-per-dir").Value.String() == "true" {
+				sfv.WriteToDirs(checksumFiles, filename, options)
+			} else if cmd.Flag("sidecar").Value.String() == "true" {
+				sfv.WriteSidecars(checksumFiles, options)
+			} else {
+				sfv.WriteToFileWithOptions(checksumFiles, filename, options)
+			}
+		}
+
+		// Stdout might be the verification file, so keep the summary out of it
+		printSummary(os.Stderr, "Processed", checksumFiles, start)
+		if findDupes, _ := cmd.Flags().GetBool("find-dupes"); findDupes {
+			printDuplicates(os.Stderr, checksumFiles)
+		}
+
+		// Files hashed before the interruption have still been written
+		interrupted := 0
+		for _, checksumFile := range checksumFiles {
+			if checksumFile.Status == sfv.StatusInterrupted {
+				interrupted++
+			}
+			if checksumFile.Status == sfv.StatusPartialRead {
+				// The checksum is of the damaged file, so it's shown but not written
+				fmt.Fprintf(os.Stderr, "%s %s, checksum %s\n", checksumFile.Filename,
+					sfv.StatusTypeToString(checksumFile.Status), checksumFile.Checksum)
+				for _, line := range badRegionLines(checksumFile) {
+					fmt.Fprintln(os.Stderr, line)
+				}
+			}
+		}
+
+		if interrupted > 0 {
+			fmt.Fprintf(os.Stderr, "Interrupted, %s files not hashed\n", formatCount(int64(interrupted)))
+			os.Exit(1)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(createCmd)
+
+	createCmd.Flags().String("files-from", "", "Read the files to process from a file, one per line")
+	createCmd.Flags().String("stdin-name", "-", "Filename to write for standard input, given as -")
+	createCmd.Flags().String("path-style", "as-given", "How filenames are recorded, {as-given, relative, absolute}")
+	createCmd.Flags().String("relative-to", "", "Directory relative filenames are recorded from, implies --path-style relative (default --base-dir or the current directory)")
+	createCmd.Flags().Bool("recursive", false, "Hash the files under the directories given, following a directory given which is a symlink")
+	createCmd.Flags().String("since", "", "Only include files modified after this time, as 2006-01-02 or RFC 3339")
+	createCmd.Flags().Bool("auto-name", false, "Name the output file after the algorithm when --file isn't given, e.g. checksums.sha256")
+	createCmd.Flags().Bool("append", false, "Add to the end of an existing --file instead of replacing it")
+	createCmd.Flags().Bool("stream", false, "Write each file as soon as it's hashed, keeping the finished files if interrupted, unless sorted with --sort-checksums")
+	createCmd.Flags().Bool("split-per-dir", false, "Write one file per directory, named by --file")
+	createCmd.Flags().String("join", "", "Hash the files given as parts of one file with this name, in the order given")
+	createCmd.Flags().Bool("quick", false, "Only fingerprint the size, first and last 4 MiB of each file, fast but not a full hash")
+	createCmd.Flags().String("output-pattern", "", "Write a file per algorithm of --type, named by replacing {alg} like in {alg}SUMS")
+	createCmd.Flags().Bool("sidecar", false, "Write one file next to each file instead, e.g. movie.mkv.sha256 for movie.mkv")
+	createCmd.Flags().Bool("sort-checksums", false, "Write the entries sorted by checksum instead of in the order given, e.g. with --format checksum")
+	createCmd.Flags().Bool("find-dupes", false, "Report the files with identical content after hashing")
+	createCmd.Flags().String("format", "", "Line format, {sfv, md5sum, sha256sum, sri, checksum} or a Go template using .Filename, .Checksum, .Size and .Type")
+	createCmd.Flags().Bool("path-hashes", false, "Record a hash of each path, to tell renamed entries from new ones")
+	createCmd.Flags().Bool("metadata", false, "Record the mode and owner of each file, verify reports when they change")
+	createCmd.Flags().Bool("manifest-hash", false, "Append a hash of the file's lines, verify warns if the file was modified")
+	createCmd.Flags().Bool("xattrs", false, "Record a hash of the extended attributes of each file, verify reports when they change")
+	createCmd.Flags().Bool("no-header", false, "Don't write the generated by comment, for reproducible output")
+	createCmd.Flags().Bool("no-timestamp", false, "Leave out the timestamp from the generated by comment")
+	createCmd.Flags().String("time-format", time.RFC3339, "Go time layout of the generated by timestamp")
+	createCmd.Flags().Bool("local-time", false, "Use local time instead of UTC in the generated by timestamp")
+}
+
+// parseTypes parses the --type, one algorithm or several separated by commas
+func parseTypes(value string) ([]sfv.ChecksumType, error) {
+	checksumTypes := make([]sfv.ChecksumType, 0)
+	for _, name := range strings.Split(value, ",") {
+		checksumType := sfv.StringToType(strings.TrimSpace(name))
+		if checksumType == sfv.TypeUnknown {
+			return nil, fmt.Errorf("Unknown algorithm: %s", name)
+		}
+
+		checksumTypes = append(checksumTypes, checksumType)
+	}
+
+	return checksumTypes, nil
+}
+
+// sortByChecksum sorts entries by their checksum, since the order of the
+// files would hint at their names
+func sortByChecksum(checksumFiles []sfv.ChecksumFile) {
+	sort.SliceStable(checksumFiles, func(i, j int) bool {
+		return checksumFiles[i].Checksum < checksumFiles[j].Checksum
+	})
+}
+
+// parsePathStyle parses the --path-style
+func parsePathStyle(value string) (sfv.PathStyle, error) {
+	switch value {
+	case "relative":
+		return sfv.PathRelative, nil
+	case "absolute":
+		return sfv.PathAbsolute, nil
+	case "as-given":
+		return sfv.PathAsGiven, nil
+	default:
+		return sfv.PathAsGiven, fmt.Errorf("Unknown path style: %s", value)
+	}
+}
+
+// parseSince parses the --since time, either a date or a full RFC 3339
+// timestamp. No time gives the zero time, which includes every file.
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if since, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return since, nil
+	}
+
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time for --since: %s", value)
+	}
+
+	return since, nil
 }
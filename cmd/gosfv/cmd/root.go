@@ -31,25 +31,132 @@ POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/lobbin/gosfv/internal/sfv"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 var cfgFile string
 
+// options is shared by all commands, the persistent flags are set here and
+// each command adds its own
+var options = sfv.DefaultOptions()
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "gosfv",
 	Short: "A tool to create and verify .sfv files",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		key, err := hmacKey(cmd)
+		if err != nil {
+			return err
+		}
+
+		options.HMACKey = key
+		options.Decompress, _ = cmd.Flags().GetBool("decompress")
+		options.BaseDir = cmd.Flag("base-dir").Value.String()
+		options.BufferSize, _ = cmd.Flags().GetInt("buffer-size")
+
+		options.Jobs, _ = cmd.Flags().GetInt("jobs")
+		if options.Jobs < 1 {
+			return fmt.Errorf("Invalid number of jobs: %d", options.Jobs)
+		}
+
+		progressInterval, err := cmd.Flags().GetDuration("progress-interval")
+		if err != nil {
+			return err
+		}
+		if progressInterval <= 0 {
+			return fmt.Errorf("Invalid progress interval: %s", progressInterval)
+		}
+		options.ProgressRefreshRate = progressInterval
+
+		options.ProgressWidth, _ = cmd.Flags().GetInt("progress-width")
+		if options.ProgressWidth < 0 {
+			return fmt.Errorf("Invalid progress width: %d", options.ProgressWidth)
+		}
+		options.StatusFile = cmd.Flag("status-file").Value.String()
+		options.SkipReadErrors, _ = cmd.Flags().GetBool("skip-read-errors")
+		options.ResumeState = cmd.Flag("resume-state").Value.String()
+		if options.ResumeState != "" && options.Jobs > 1 {
+			return errors.New("Option --resume-state can't be combined with --jobs")
+		}
+
+		switch style := cmd.Flag("progress").Value.String(); style {
+		case "bar":
+			options.ProgressStyle = sfv.ProgressStyleBar
+		case "log":
+			options.ProgressStyle = sfv.ProgressStyleLog
+
+			// A line every redraw of the bar would flood the log
+			if !isSet(cmd, "progress-interval") {
+				options.ProgressRefreshRate = 30 * time.Second
+			}
+		default:
+			return fmt.Errorf("Unknown progress style: %s", style)
+		}
+
+		switch unit := cmd.Flag("progress-unit").Value.String(); unit {
+		case "bytes":
+			options.ProgressUnit = sfv.ProgressBytes
+		case "files":
+			options.ProgressUnit = sfv.ProgressFiles
+		default:
+			return fmt.Errorf("Unknown progress unit: %s", unit)
+		}
+
+		switch encoding := cmd.Flag("encoding").Value.String(); encoding {
+		case "hex":
+			options.Encoding = sfv.EncodingHex
+		case "base64":
+			options.Encoding = sfv.EncodingBase64
+		default:
+			return fmt.Errorf("Unknown encoding: %s", encoding)
+		}
+
+		if inputEncoding := cmd.Flag("input-encoding").Value.String(); inputEncoding != "" {
+			options.InputEncoding, err = htmlindex.Get(inputEncoding)
+			if err != nil {
+				return fmt.Errorf("Unknown input encoding: %s", inputEncoding)
+			}
+		}
+
+		progressFd, err := cmd.Flags().GetInt("progress-fd")
+		if err != nil {
+			return err
+		}
+
+		if progressFd >= 0 {
+			options.ProgressWriter = os.NewFile(uintptr(progressFd), "progress")
+		}
+
+		// Stdout might be the verification file, so keep the timings out of it
+		if timings, _ := cmd.Flags().GetBool("timings"); timings {
+			options.TimingsWriter = os.Stderr
+		}
+
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	// The version is set by main, after init has run
+	rootCmd.Version = sfv.Version
+	rootCmd.SetVersionTemplate(versionString() + "\n")
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -59,10 +166,66 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gosfv.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file setting defaults for the flags, named like them (default is $HOME/.gosfv.yaml)")
 
 	rootCmd.PersistentFlags().StringP("file", "f", "", "Output file (default stdout)")
-	rootCmd.PersistentFlags().StringP("type", "t", "crc32", "Verification algorithm, {crc32, md5, sha1, sha256}")
+	defaultType := os.Getenv("GOSFV_DEFAULT_TYPE")
+	if defaultType == "" {
+		defaultType = "crc32"
+	}
+	rootCmd.PersistentFlags().StringP("type", "t", defaultType, "Verification algorithm, {crc32, md5, sha1, sha256, sha384, sha512, sha512-256, hmac-sha256, ed2k}, defaults to $GOSFV_DEFAULT_TYPE if set")
+	rootCmd.PersistentFlags().String("hmac-key", "", "Secret key for hmac-sha256")
+	rootCmd.PersistentFlags().String("hmac-key-file", "", "File containing the secret key for hmac-sha256")
+	rootCmd.PersistentFlags().String("base-dir", "", "Directory the files are relative to (default current directory)")
+	rootCmd.PersistentFlags().Int("buffer-size", 64*1024, "Size of the buffer files are read with, larger buffers mean fewer reads but more memory")
+	rootCmd.PersistentFlags().IntP("jobs", "j", 1, "Number of files hashed at once, more can be faster on SSDs but slower on hard drives")
+	rootCmd.PersistentFlags().Bool("decompress", false, "Hash .gz, .xz and .zst files by their decompressed content")
+	rootCmd.PersistentFlags().String("progress", "bar", "How progress is shown, {bar, log}, log prints a plain line every --progress-interval")
+	rootCmd.PersistentFlags().String("resume-state", "", "Save the state of hashing to this file, to resume hashing interrupted files where they stopped")
+	rootCmd.PersistentFlags().Bool("skip-read-errors", false, "Zero-fill the parts of files which can't be read and go on, reporting where they are")
+	rootCmd.PersistentFlags().String("status-file", "", "File rewritten every few seconds with the progress, to follow a run from another terminal")
+	rootCmd.PersistentFlags().Duration("progress-interval", 200*time.Millisecond, "How often the progress bar is redrawn, 30s by default with --progress log")
+	rootCmd.PersistentFlags().Int("progress-width", 0, "Width of the progress bar in columns, 0 for the width of the terminal")
+	rootCmd.PersistentFlags().String("progress-unit", "bytes", "What the progress bar counts, {bytes, files}")
+	rootCmd.PersistentFlags().String("encoding", "hex", "How checksums are written and read, {hex, base64}")
+	rootCmd.PersistentFlags().String("input-encoding", "", "Character encoding verification files are read in, e.g. windows-1252 or shift_jis (default UTF-8)")
+	rootCmd.PersistentFlags().Int("progress-fd", -1, "File descriptor to write JSON progress events to")
+	rootCmd.PersistentFlags().Bool("timings", false, "Print when each file started hashing relative to the run, how long it took and its throughput, to stderr")
+}
+
+// stopOnSignal returns a channel which is closed when the process is
+// interrupted or terminated
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		close(stop)
+	}()
+
+	return stop
+}
+
+// hmacKey returns the HMAC key given either directly or through a key file.
+func hmacKey(cmd *cobra.Command) ([]byte, error) {
+	if key := cmd.Flag("hmac-key").Value.String(); key != "" {
+		return []byte(key), nil
+	}
+
+	keyFile := cmd.Flag("hmac-key-file").Value.String()
+	if keyFile == "" {
+		return nil, nil
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Key files are usually written with a trailing newline
+	return []byte(strings.TrimRight(string(key), "\r\n")), nil
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -83,10 +246,22 @@ func initConfig() {
 		viper.SetConfigName(".gosfv")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// Read in environment variables that match, like GOSFV_JOBS for jobs
+	viper.SetEnvPrefix("gosfv")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	viper.AutomaticEnv()
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in. Stdout might be the
+	// verification file, so say so on stderr.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	} else if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := applyConfig(rootCmd); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,152 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lobbin/gosfv/internal/sfv"
+)
+
+// printSummary prints the number of processed files, the amount of data read
+// and the time it took.
+func printSummary(w io.Writer, verb string, checksumFiles []sfv.ChecksumFile, start time.Time) {
+	var totalFileSize int64
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status == sfv.StatusCheckSumOK || checksumFile.Status == sfv.StatusCheckSumNoMatch ||
+			checksumFile.Status == sfv.StatusUnstableRead || checksumFile.Status == sfv.StatusMetadataChanged ||
+			checksumFile.Status == sfv.StatusPartialRead {
+			totalFileSize += checksumFile.Filesize
+		}
+	}
+
+	fmt.Fprintf(w, "%s %s files, %s in %s\n", verb, formatCount(int64(len(checksumFiles))),
+		formatBytes(totalFileSize), time.Since(start).Round(time.Millisecond))
+}
+
+// printGroupSummary prints the number of files of each status per top-level
+// directory, to localize problems to a subtree.
+func printGroupSummary(w io.Writer, checksumFiles []sfv.ChecksumFile) {
+	groups := make([]string, 0)
+	groupStatuses := make(map[string]map[sfv.ChecksumStatus]int64)
+	for _, checksumFile := range checksumFiles {
+		group := topLevelDir(checksumFile.Filename)
+		if _, ok := groupStatuses[group]; !ok {
+			groups = append(groups, group)
+			groupStatuses[group] = make(map[sfv.ChecksumStatus]int64)
+		}
+
+		groupStatuses[group][checksumFile.Status]++
+	}
+
+	for _, group := range groups {
+		statuses := make([]sfv.ChecksumStatus, 0, len(groupStatuses[group]))
+		for status, _ := range groupStatuses[group] {
+			statuses = append(statuses, status)
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i] < statuses[j] })
+
+		counts := make([]string, len(statuses))
+		for i, status := range statuses {
+			counts[i] = formatCount(groupStatuses[group][status]) + " " + sfv.StatusTypeToString(status)
+		}
+
+		fmt.Fprintf(w, "%s: %s\n", group, strings.Join(counts, ", "))
+	}
+}
+
+// printDuplicates prints the sets of files with identical content
+func printDuplicates(w io.Writer, checksumFiles []sfv.ChecksumFile) {
+	duplicates := sfv.Duplicates(checksumFiles)
+	for _, filenames := range duplicates {
+		fmt.Fprintf(w, "Identical content: %s\n", strings.Join(filenames, ", "))
+	}
+
+	fmt.Fprintf(w, "Found %s sets of duplicates\n", formatCount(int64(len(duplicates))))
+}
+
+// topLevelDir returns the first component of a relative path, or . for
+// files without a directory
+func topLevelDir(filename string) string {
+	filename = filepath.ToSlash(filepath.Clean(filename))
+	if i := strings.Index(filename, "/"); i >= 0 {
+		if i == 0 {
+			return "/"
+		}
+		return filename[:i]
+	}
+
+	return "."
+}
+
+// formatCount formats n with thousands separators, e.g. 1,234
+func formatCount(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+
+	return s
+}
+
+// formatBytes formats n as a human readable size, e.g. 2.3 TB
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// badRegionLines describes the damaged regions of a file read with
+// --skip-read-errors, one line each, so it's clear where the file is damaged
+func badRegionLines(checksumFile sfv.ChecksumFile) []string {
+	lines := make([]string, 0, len(checksumFile.BadRegions))
+	for _, region := range checksumFile.BadRegions {
+		lines = append(lines, fmt.Sprintf("    %s bytes %d-%d (%s) unreadable, hashed as zeros", checksumFile.Filename,
+			region.Start, region.End-1, formatBytes(region.End-region.Start)))
+	}
+
+	return lines
+}
@@ -31,29 +31,360 @@ POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/lobbin/gosfv/internal/sfv"
 	"github.com/spf13/cobra"
 )
 
+var (
+	colorOK      = color.New(color.FgGreen)
+	colorFailed  = color.New(color.FgRed)
+	colorSkipped = color.New(color.FgYellow)
+)
+
 // verifyCmd represents the verify command
 var verifyCmd = &cobra.Command{
-	Use:   "verify",
+	Use:   "verify [flags] [files]",
 	Short: "Generate a new verfication file",
+	Long: `Verify the files listed in a verification file.
+
+A tar archive, optionally gzip compressed, or a zip archive given as --file is
+searched for a verification file, or the member named by --manifest, which the
+other members of the archive are verified against.
+
+A PAR2 file given as --file is read for its listing of files and their MD5
+checksums, the recovery data isn't used.
+
+With --sidecar the files given are verified against the sidecar file next to
+each of them instead, like movie.mkv.sha256 for movie.mkv. With --recursive
+the arguments are directories, and every file in their trees is verified
+against its sidecar. Files without one are reported but not checked.
+
+With --by-content the files given are verified against a list of bare
+checksums given as --file, like the one written by create --format checksum.
+A file is OK if its checksum is anywhere in the list, whatever its name.
+
+With --hash-in-name the files given are verified against the checksum in
+their name, the first group of the regular expression matched against the
+base name. The algorithm is told by the length of the checksum unless
+--force-type is given.
+
+With --verify-signature the verification file is only trusted once its
+detached signature is valid, checked with minisign for .minisig files and
+with gpg otherwise.
+
+With --output ndjson each result is written to stdout as one line of JSON
+as soon as the file is done, with its filename, type, status, computed and
+expected checksums and size. The summary goes to stderr.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		colorValue := cmd.Flag("color").Value.String()
+		if colorValue != "auto" && colorValue != "always" && colorValue != "never" {
+			return fmt.Errorf("Unknown color mode: %s", colorValue)
+		}
+
+		if sfv.IsArchive(cmd.Flag("file").Value.String()) &&
+			(cmd.Flag("watch").Value.String() == "true" || cmd.Flag("find").Value.String() != "") {
+			return errors.New("Options --watch and --find can't be used with archives")
+		}
+
+		// Archive members are read once as they're stored, so there's no
+		// reading them again or picking some to skip the rest
+		if sfv.IsArchive(cmd.Flag("file").Value.String()) &&
+			(cmd.Flag("sample").Value.String() != "" || cmd.Flag("rehash-on-mismatch").Value.String() == "true") {
+			return errors.New("Options --sample and --rehash-on-mismatch can't be used with archives")
+		}
+
+		audit := cmd.Flag("audit").Value.String() == "true" || cmd.Flag("fail-on-extra").Value.String() == "true"
+		if audit && (cmd.Flag("file").Value.String() == "" || sfv.IsArchive(cmd.Flag("file").Value.String()) ||
+			cmd.Flag("sidecar").Value.String() == "true" || cmd.Flag("by-content").Value.String() == "true") {
+			return errors.New("Options --audit and --fail-on-extra need a verification file given as --file")
+		}
+
+		if cmd.Flag("sidecar").Value.String() == "true" {
+			if len(args) < 1 {
+				return errors.New("Option --sidecar needs at least one file argument")
+			}
+
+			if cmd.Flag("file").Value.String() != "" || cmd.Flag("watch").Value.String() == "true" ||
+				cmd.Flag("find").Value.String() != "" {
+				return errors.New("Option --sidecar can't be combined with --file, --watch or --find")
+			}
+		} else if cmd.Flag("by-content").Value.String() == "true" {
+			if len(args) < 1 || cmd.Flag("file").Value.String() == "" {
+				return errors.New("Option --by-content needs --file and at least one file argument")
+			}
+
+			if sfv.IsArchive(cmd.Flag("file").Value.String()) || cmd.Flag("watch").Value.String() == "true" ||
+				cmd.Flag("find").Value.String() != "" {
+				return errors.New("Option --by-content can't be combined with archives, --watch or --find")
+			}
+		} else if hashInName := cmd.Flag("hash-in-name").Value.String(); hashInName != "" {
+			re, err := regexp.Compile(hashInName)
+			if err != nil {
+				return fmt.Errorf("Invalid --hash-in-name: %s", err)
+			}
+			if re.NumSubexp() < 1 {
+				return errors.New("Option --hash-in-name needs a group capturing the checksum")
+			}
+
+			if len(args) < 1 {
+				return errors.New("Option --hash-in-name needs at least one file argument")
+			}
+
+			if cmd.Flag("file").Value.String() != "" || cmd.Flag("watch").Value.String() == "true" ||
+				cmd.Flag("find").Value.String() != "" {
+				return errors.New("Option --hash-in-name can't be combined with --file, --watch or --find")
+			}
+		} else if len(args) > 0 {
+			return errors.New("File arguments are only accepted with --sidecar, --by-content or --hash-in-name")
+		}
+
+		if output := cmd.Flag("output").Value.String(); output != "text" && output != "ndjson" {
+			return fmt.Errorf("Unknown output %s, expected text or ndjson", output)
+		} else if output == "ndjson" &&
+			(cmd.Flag("watch").Value.String() == "true" || cmd.Flag("find").Value.String() != "") {
+			return errors.New("Option --output ndjson can't be combined with --watch or --find")
+		}
+
+		if cmd.Flag("recursive").Value.String() == "true" && cmd.Flag("sidecar").Value.String() != "true" {
+			return errors.New("Option --recursive needs --sidecar")
+		}
+
+		if cmd.Flag("manifest").Value.String() != "" && !sfv.IsArchive(cmd.Flag("file").Value.String()) {
+			return errors.New("Option --manifest needs an archive given as --file")
+		}
+
+		if cmd.Flag("verify-signature").Value.String() != "" && (cmd.Flag("file").Value.String() == "" ||
+			sfv.IsArchive(cmd.Flag("file").Value.String()) || cmd.Flag("sidecar").Value.String() == "true" ||
+			cmd.Flag("by-content").Value.String() == "true") {
+			return errors.New("Option --verify-signature needs a verification file given as --file")
+		}
+
+		if onlyTypes := cmd.Flag("only-types").Value.String(); onlyTypes != "" {
+			if _, err := parseTypes(onlyTypes); err != nil {
+				return err
+			}
+
+			if sfv.IsArchive(cmd.Flag("file").Value.String()) || cmd.Flag("sidecar").Value.String() == "true" ||
+				cmd.Flag("by-content").Value.String() == "true" || cmd.Flag("hash-in-name").Value.String() != "" ||
+				cmd.Flag("watch").Value.String() == "true" {
+				return errors.New("Option --only-types can't be combined with archives, --sidecar, --by-content, --hash-in-name or --watch")
+			}
+		}
+
+		if _, _, err := parseSample(cmd.Flag("sample").Value.String()); err != nil {
+			return err
+		}
+
+		forceType := cmd.Flag("force-type").Value.String()
+		if forceType != "" && sfv.StringToType(forceType) == sfv.TypeUnknown {
+			return fmt.Errorf("Unknown algorithm: %s", forceType)
+		}
+
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		checksumFiles := sfv.Verify(cmd.Flag("file").Value.String())
+		setupColor(cmd.Flag("color").Value.String())
+		options.Strict, _ = cmd.Flags().GetBool("strict")
+		options.ForceType = sfv.StringToType(cmd.Flag("force-type").Value.String())
+		options.TrimPrefix = cmd.Flag("trim-prefix").Value.String()
+		options.AddPrefix = cmd.Flag("add-prefix").Value.String()
+		options.NoSymlinks, _ = cmd.Flags().GetBool("no-symlinks")
+		options.RehashOnMismatch, _ = cmd.Flags().GetBool("rehash-on-mismatch")
+		options.JoinParts, _ = cmd.Flags().GetBool("join-parts")
+		options.RequirePresent, _ = cmd.Flags().GetFloat64("require-present")
+		options.ArchiveManifest = cmd.Flag("manifest").Value.String()
+		options.SignatureFile = cmd.Flag("verify-signature").Value.String()
+		if onlyTypes := cmd.Flag("only-types").Value.String(); onlyTypes != "" {
+			options.OnlyTypes, _ = parseTypes(onlyTypes)
+		}
+		options.SignatureKey = cmd.Flag("signature-key").Value.String()
+		options.Stop = stopOnSignal()
+
+		sample := cmd.Flag("sample").Value.String()
+		options.SampleSize, options.SamplePercent, _ = parseSample(sample)
+		options.SampleSeed, _ = cmd.Flags().GetInt64("seed")
+		if !isSet(cmd, "seed") {
+			options.SampleSeed = time.Now().UnixNano()
+		}
+
+		if find := cmd.Flag("find").Value.String(); find != "" {
+			findFile(cmd.Flag("file").Value.String(), find)
+			return
+		}
+
+		// A sample leaves out listed entries, which aren't unlisted though
+		var listed []sfv.ChecksumFile
+		options.ListedFunc = func(checksumFiles []sfv.ChecksumFile) {
+			listed = checksumFiles
+		}
+
+		ndjson := cmd.Flag("output").Value.String() == "ndjson"
+		if ndjson {
+			options.ResultFunc = writeResult(os.Stdout)
+		}
+
+		start := time.Now()
+		var checksumFiles []sfv.ChecksumFile
+		recursive, _ := cmd.Flags().GetBool("recursive")
+		if sidecar, _ := cmd.Flags().GetBool("sidecar"); sidecar {
+			files := args
+			if recursive {
+				files = sfv.SidecarFiles(args, options)
+			}
+			checksumFiles = sfv.VerifySidecarsWithOptions(files, options)
+		} else if hashInName := cmd.Flag("hash-in-name").Value.String(); hashInName != "" {
+			checksumFiles = sfv.VerifyNamedWithOptions(args, regexp.MustCompile(hashInName), options)
+		} else if byContent, _ := cmd.Flags().GetBool("by-content"); byContent {
+			checksumFiles = sfv.VerifyContentWithOptions(cmd.Flag("file").Value.String(), args, options)
+		} else if file := cmd.Flag("file").Value.String(); sfv.IsArchive(file) {
+			checksumFiles = sfv.VerifyArchiveWithOptions(file, options)
+		} else {
+			checksumFiles = sfv.VerifyWithOptions(file, options)
+		}
+		if len(checksumFiles) == 0 {
+			fmt.Println("No checksums found")
+			os.Exit(2)
+		}
+
+		// The results were written as JSON while verifying, so stdout is kept
+		// for them and the rest goes to stderr
+		report := color.Output
+		if ndjson {
+			report = color.Error
+		}
+
+		ignoreMissing, _ := cmd.Flags().GetBool("ignore-missing")
+		failOnMetadata, _ := cmd.Flags().GetBool("fail-on-metadata")
 
 		error := false
+		verifiedFiles := make([]sfv.ChecksumFile, 0, len(checksumFiles))
+		skipped := 0
+		unstable := 0
+		metadataChanged := 0
+		typeSkipped := 0
+		unchecked := 0
+		partial := 0
 		for _, checksumFile := range checksumFiles {
-			fmt.Printf("%s %s\n", checksumFile.Filename, sfv.StatusTypeToString(checksumFile.Status))
+			if checksumFile.Status == sfv.StatusTypeSkipped {
+				typeSkipped++
+				continue
+			}
+
+			// Walking a tree finds files which were never meant to have one
+			if recursive && checksumFile.Status == sfv.StatusNoSidecar {
+				if !ndjson {
+					fmt.Fprintf(report, "%s %s\n", displayName(checksumFile), colorSkipped.Sprint("No sidecar, not checked"))
+				}
+
+				unchecked++
+				continue
+			}
+
+			if ignoreMissing && checksumFile.Status == sfv.StatusNotFound {
+				if !ndjson {
+					status := colorSkipped.Sprint(sfv.StatusTypeToString(checksumFile.Status) + ", skipped")
+					fmt.Fprintf(report, "%s %s\n", displayName(checksumFile), status)
+				}
+
+				skipped++
+				continue
+			}
+
+			if !ndjson {
+				status := statusColor(checksumFile.Status).Sprint(sfv.StatusTypeToString(checksumFile.Status))
+				fmt.Fprintf(report, "%s %s\n", displayName(checksumFile), status)
+				if checksumFile.Status == sfv.StatusPartialRead {
+					for _, line := range badRegionLines(checksumFile) {
+						fmt.Fprintln(report, colorFailed.Sprint(line))
+					}
+				}
+			}
+			if checksumFile.Status == sfv.StatusPartialRead {
+				partial++
+			}
+
+			if (checksumFile.Status != sfv.StatusCheckSumOK && checksumFile.Status != sfv.StatusSymlinkSkipped &&
+				checksumFile.Status != sfv.StatusMetadataChanged) {
+				error = true
+			}
+			if checksumFile.Status == sfv.StatusUnstableRead {
+				unstable++
+			}
+			if checksumFile.Status == sfv.StatusMetadataChanged {
+				metadataChanged++
+				if failOnMetadata {
+					error = true
+				}
+			}
+
+			verifiedFiles = append(verifiedFiles, checksumFile)
+		}
+
+		if groupByDir, _ := cmd.Flags().GetBool("group-by-dir"); groupByDir {
+			printGroupSummary(report, verifiedFiles)
+		}
+		printSummary(report, "Verified", verifiedFiles, start)
+		if findDupes, _ := cmd.Flags().GetBool("find-dupes"); findDupes {
+			printDuplicates(report, verifiedFiles)
+		}
+		if sample != "" {
+			fmt.Fprintf(report, "Verified a random sample of %s entries, repeat it with --seed %d\n", sample, options.SampleSeed)
+		}
+		if skipped > 0 {
+			fmt.Fprintf(report, "Skipped %s missing files\n", formatCount(int64(skipped)))
+		}
+		if unchecked > 0 {
+			fmt.Fprintf(report, "Found %s files without a sidecar, not checked\n", formatCount(int64(unchecked)))
+		}
+		if typeSkipped > 0 {
+			fmt.Fprintf(report, "Filtered out %s entries not of --only-types\n", formatCount(int64(typeSkipped)))
+		}
+		if partial > 0 {
+			fmt.Fprintf(report, "%s files had unreadable regions, their checksums can't match\n", formatCount(int64(partial)))
+		}
+		if unstable > 0 {
+			fmt.Fprintf(report, "%s files produced different bytes on re-read, check the hardware\n", formatCount(int64(unstable)))
+		}
+		if metadataChanged > 0 {
+			fmt.Fprintf(report, "%s files match but had their mode, owner or extended attributes changed\n", formatCount(int64(metadataChanged)))
+		}
+
+		failOnExtra, _ := cmd.Flags().GetBool("fail-on-extra")
+		if audit, _ := cmd.Flags().GetBool("audit"); audit || failOnExtra {
+			if listed == nil {
+				listed = checksumFiles
+			}
+			unlisted := sfv.Unlisted(listed, cmd.Flag("file").Value.String(), options)
+			for _, filename := range unlisted {
+				fmt.Fprintf(report, "%s %s\n", filename, colorSkipped.Sprint("Not listed"))
+			}
+			fmt.Fprintf(report, "Found %s files not listed\n", formatCount(int64(len(unlisted))))
 
-			if (checksumFile.Status != sfv.StatusCheckSumOK) {
+			if failOnExtra && len(unlisted) > 0 {
 				error = true
 			}
 		}
 
+		if emitFailures := cmd.Flag("emit-failures").Value.String(); emitFailures != "" {
+			sfv.WriteToFileWithOptions(sfv.Failures(withoutSkipped(verifiedFiles)), emitFailures, options)
+		}
+
+		if watch, _ := cmd.Flags().GetBool("watch"); watch {
+			watchFiles(checksumFiles)
+			return
+		}
+
 		if error {
 			os.Exit(1)
 		}
@@ -62,4 +393,156 @@ var verifyCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().String("color", "auto", "Colorize the output, {auto, always, never}")
+	verifyCmd.Flags().String("output", "text", "How results are written, {text, ndjson}, ndjson writes a JSON object per file to stdout as soon as it's done")
+	verifyCmd.Flags().String("force-type", "", "Verify every file with this algorithm regardless of the line format")
+	verifyCmd.Flags().String("manifest", "", "Archive member to read as the verification file, e.g. SHA256SUMS")
+	verifyCmd.Flags().String("trim-prefix", "", "Remove this prefix from the filenames to verify")
+	verifyCmd.Flags().String("add-prefix", "", "Add this prefix to the filenames to verify")
+	verifyCmd.Flags().Float64("require-present", 0, "Stop before hashing if fewer than this percentage of the files exist")
+	verifyCmd.Flags().Bool("join-parts", false, "Verify missing files split into parts like name.001, name.002 by the parts joined")
+	verifyCmd.Flags().Bool("ignore-missing", false, "Skip missing files instead of failing on them")
+	verifyCmd.Flags().Bool("no-symlinks", false, "Skip files which are symlinks instead of verifying their targets")
+	verifyCmd.Flags().Bool("rehash-on-mismatch", false, "Read files which don't match once more before reporting them, to rule out read errors")
+	verifyCmd.Flags().String("emit-failures", "", "Write the entries which failed to this file, with their expected checksums")
+	verifyCmd.Flags().String("sample", "", "Only verify this many entries, or percentage with %, picked at random")
+	verifyCmd.Flags().Int64("seed", 0, "Seed for picking the --sample, random unless given")
+	verifyCmd.Flags().Bool("find-dupes", false, "Report the files with identical content after verifying")
+	verifyCmd.Flags().Bool("audit", false, "Also list the files in --base-dir or the current directory which aren't in the verification file")
+	verifyCmd.Flags().String("only-types", "", "Only verify entries of these algorithms, separated by commas, like sha256,sha512")
+	verifyCmd.Flags().String("verify-signature", "", "Detached minisign (.minisig) or GPG signature the verification file must match")
+	verifyCmd.Flags().String("signature-key", "", "Minisign public key or GPG keyring to check --verify-signature with")
+	verifyCmd.Flags().Bool("fail-on-metadata", false, "Fail if files match but their recorded mode, owner or extended attributes changed")
+	verifyCmd.Flags().Bool("fail-on-extra", false, "Fail if there are files which aren't in the verification file, implies --audit")
+	verifyCmd.Flags().Bool("group-by-dir", false, "Summarize the results per top-level directory")
+	verifyCmd.Flags().Bool("watch", false, "Keep verifying files as they change on disk")
+	verifyCmd.Flags().Bool("sidecar", false, "Verify the files given against the sidecar file next to each, e.g. movie.mkv.sha256")
+	verifyCmd.Flags().String("hash-in-name", "", "Verify the files given against the checksum captured from their name by this regular expression")
+	verifyCmd.Flags().Bool("recursive", false, "With --sidecar, verify every file in the directories given against its sidecar")
+	verifyCmd.Flags().Bool("by-content", false, "Verify the files given against a list of bare checksums, matching by content instead of name")
+	verifyCmd.Flags().String("find", "", "Find the entries matching the content of this file instead of verifying")
+	verifyCmd.Flags().Bool("strict", false, "Fail on malformed verification files instead of warning")
+}
+
+// findFile prints the entries matching the content of file, which is useful
+// for identifying misnamed or duplicate files
+func findFile(manifest string, file string) {
+	matches := sfv.FindWithOptions(manifest, file, options)
+	if len(matches) == 0 {
+		fmt.Printf("%s doesn't match any entry\n", file)
+		os.Exit(1)
+	}
+
+	for _, checksumFile := range matches {
+		fmt.Printf("%s matches %s\n", file, checksumFile.Filename)
+	}
+}
+
+// watchFiles verifies files again when they change, until interrupted
+func watchFiles(checksumFiles []sfv.ChecksumFile) {
+	stop := options.Stop
+
+	// Rechecks aren't interrupted by the stop of the initial verification
+	options.Stop = nil
+
+	fmt.Println("Watching for changes, press Ctrl-C to stop")
+	err := sfv.Watch(checksumFiles, options, func(checksumFile sfv.ChecksumFile) {
+		status := statusColor(checksumFile.Status).Sprint(sfv.StatusTypeToString(checksumFile.Status))
+		fmt.Fprintf(color.Output, "%s %s %s\n", time.Now().Format(time.RFC3339), displayName(checksumFile), status)
+	}, stop)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// parseSample parses the --sample size, either a number of entries or a
+// percentage like 5%
+func parseSample(value string) (int, float64, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, 0, fmt.Errorf("Invalid sample percentage: %s", value)
+		}
+
+		return 0, percent, nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return 0, 0, fmt.Errorf("Invalid sample size: %s", value)
+	}
+
+	return size, 0, nil
+}
+
+// withoutSkipped returns the entries which weren't skipped on purpose
+func withoutSkipped(checksumFiles []sfv.ChecksumFile) []sfv.ChecksumFile {
+	filtered := make([]sfv.ChecksumFile, 0, len(checksumFiles))
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status != sfv.StatusSymlinkSkipped {
+			filtered = append(filtered, checksumFile)
+		}
+	}
+
+	return filtered
+}
+
+// writeResult returns a function writing each result to w as a line of JSON
+func writeResult(w io.Writer) func(sfv.ChecksumFile) {
+	encoder := json.NewEncoder(w)
+
+	return func(checksumFile sfv.ChecksumFile) {
+		encoder.Encode(struct {
+			Filename string `json:"filename"`
+			Type     string `json:"type"`
+			Status   string `json:"status"`
+			Computed string `json:"computed"`
+			Expected string `json:"expected"`
+			Size     int64  `json:"size"`
+		}{checksumFile.Filename, sfv.TypeToString(checksumFile.ChecksumType),
+			sfv.StatusTypeToString(checksumFile.Status), checksumFile.Checksum, checksumFile.ChecksumWant,
+			checksumFile.Filesize})
+	}
+}
+
+// displayName returns the filename to print, marking symlinks so it's clear
+// their target was verified
+func displayName(checksumFile sfv.ChecksumFile) string {
+	if checksumFile.Symlink {
+		return checksumFile.Filename + " (symlink)"
+	}
+
+	return checksumFile.Filename
+}
+
+// setupColor enables or disables colors. In auto mode colors are used only
+// when writing to a terminal and NO_COLOR isn't set.
+func setupColor(mode string) {
+	switch mode {
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		if os.Getenv("NO_COLOR") != "" {
+			color.NoColor = true
+		}
+	}
+}
+
+func statusColor(s sfv.ChecksumStatus) *color.Color {
+	switch s {
+	case sfv.StatusCheckSumOK:
+		return colorOK
+	case sfv.StatusMissingKey, sfv.StatusSymlinkSkipped, sfv.StatusMetadataChanged:
+		return colorSkipped
+	default:
+		return colorFailed
+	}
 }
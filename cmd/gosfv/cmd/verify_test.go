@@ -0,0 +1,138 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testArgsEnv passes the arguments to gosfv run by runGosfv
+const testArgsEnv = "SFV_TEST_ARGS"
+
+// TestMain runs gosfv instead of the tests when started by runGosfv, since
+// commands exit the process
+func TestMain(m *testing.M) {
+	if args := os.Getenv(testArgsEnv); args != "" {
+		rootCmd.SetArgs(strings.Split(args, "\n"))
+		Execute()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runGosfv runs gosfv with args in dir and returns its exit code and output
+func runGosfv(t *testing.T, dir string, args ...string) (int, string) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Dir = dir
+	// A config file in the real home would change the defaults
+	cmd.Env = append(os.Environ(), testArgsEnv+"="+strings.Join(args, "\n"), "HOME="+dir)
+
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), string(output)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return 0, string(output)
+}
+
+func TestVerifyNoChecksums(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty.sfv", ""},
+		{"comments.sfv", "; Generated by gosfv\n; size 6 hello.txt\n"},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filepath.Join(dir, test.name), []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		code, output := runGosfv(t, dir, "verify", "-f", test.name)
+		if code != 2 || !strings.Contains(output, "No checksums found") {
+			t.Errorf("%s: exit code %d, output %q, want 2 and No checksums found", test.name, code, output)
+		}
+	}
+}
+
+func TestVerifySampleAudit(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":    "hello\n",
+		"b.txt":    "hello\n",
+		"test.sfv": "a.txt 363a3020\nb.txt 363a3020\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The entries left out of the sample are still listed
+	code, output := runGosfv(t, dir, "verify", "--sample", "1", "--seed", "1", "--fail-on-extra", "-f", "test.sfv")
+	if code != 0 || strings.Contains(output, "Not listed") {
+		t.Errorf("exit code %d, output %q, want 0 and no unlisted files", code, output)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "c.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, output = runGosfv(t, dir, "verify", "--sample", "1", "--seed", "1", "--fail-on-extra", "-f", "test.sfv")
+	if code != 1 || !strings.Contains(output, "Found 1 files not listed") {
+		t.Errorf("exit code %d, output %q, want 1 and one file not listed", code, output)
+	}
+}
+
+func TestVerifyArchiveOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"verify", "--sample", "1", "-f", "backup.tar"},
+		{"verify", "--rehash-on-mismatch", "-f", "backup.tar"},
+	} {
+		code, output := runGosfv(t, dir, args...)
+		if code != 1 || !strings.Contains(output, "can't be used with archives") {
+			t.Errorf("%v: exit code %d, output %q, want 1 and rejected", args, code, output)
+		}
+	}
+}
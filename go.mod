@@ -4,7 +4,12 @@ go 1.15
 
 require (
 	github.com/cheggaaa/pb/v3 v3.0.5
+	github.com/fatih/color v1.7.0
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/spf13/cobra v1.1.1
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
+	golang.org/x/sys v0.0.0-20200116001909-b77594299b42
+	golang.org/x/text v0.3.2
 )
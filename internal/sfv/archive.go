@@ -0,0 +1,254 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// manifestNames are base names of archive members taken to be the
+// verification file, besides names with one of manifestExtensions
+var manifestNames = []string{"MD5SUMS", "SHA1SUMS", "SHA256SUMS", "SHA384SUMS", "SHA512SUMS"}
+
+var manifestExtensions = []string{".sfv", ".md5", ".sha1", ".sha256", ".sha384", ".sha512"}
+
+// IsArchive tells whether a file is a tar archive, possibly gzip compressed,
+// or a zip archive
+func IsArchive(filename string) bool {
+	return strings.HasSuffix(filename, ".tar") ||
+	       strings.HasSuffix(filename, ".tar.gz") ||
+	       strings.HasSuffix(filename, ".tgz") ||
+	       strings.HasSuffix(filename, ".zip")
+}
+
+// VerifyArchiveWithOptions verifies the members of an archive against the
+// verification file in the same archive, the member named
+// Options.ArchiveManifest or else the first which looks like one. Filenames
+// are relative to the directory of the verification file within the archive.
+// Every member is read once, so Options.SampleSize, SamplePercent and
+// RehashOnMismatch don't apply.
+func VerifyArchiveWithOptions(archive string, opts Options) []ChecksumFile {
+	// Archives can only be read from start to end, so a first pass finds the
+	// verification file and the sizes of the members
+	var manifest []byte
+	manifestName := ""
+	sizes := make(map[string]int64)
+	err := walkArchive(archive, func(name string, size int64, reader io.Reader) error {
+		sizes[name] = size
+
+		isManifest := isManifestName(name)
+		if opts.ArchiveManifest != "" {
+			isManifest = name == path.Clean(opts.ArchiveManifest)
+		}
+
+		if manifestName == "" && isManifest {
+			var err error
+			manifestName = name
+			manifest, err = ioutil.ReadAll(reader)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if manifestName == "" && opts.ArchiveManifest != "" {
+		log.Fatalf("%s: no member named %s", archive, opts.ArchiveManifest)
+	} else if manifestName == "" {
+		log.Fatalf("%s: no verification file found in the archive", archive)
+	}
+
+	checksumFiles := parseSfvReader(bytes.NewReader(manifest), archive+":"+manifestName, &opts)
+
+	members := make(map[string]int)
+	dups := make(map[int]int)
+	for i, _ := range checksumFiles {
+		checksumFiles[i].Filename = mapFilename(checksumFiles[i].Filename, &opts)
+
+		member := path.Join(path.Dir(manifestName), checksumFiles[i].Filename)
+		size, ok := sizes[member]
+		if !ok {
+			checksumFiles[i].Status = StatusNotFound
+			continue
+		}
+
+		checksumFiles[i].Status   = StatusOK
+		checksumFiles[i].Filesize = size
+		checkFilesize(&checksumFiles[i])
+
+		// A member can only be read once, entries listing it again are
+		// compared with the checksum of the first
+		if j, ok := members[member]; ok && checksumFiles[i].Status == StatusOK {
+			dups[i] = j
+		} else if checksumFiles[i].Status == StatusOK {
+			members[member] = i
+		}
+	}
+
+	checkPresent(checksumFiles, &opts)
+
+	progress := newProgress(checksumFiles, &opts)
+	progress.start()
+
+	read := make([]bool, len(checksumFiles))
+	hashed := make(map[int]ChecksumFile)
+	err = walkArchive(archive, func(name string, size int64, reader io.Reader) error {
+		if i, ok := members[name]; ok && !read[i] {
+			calculateReaderChecksum(&checksumFiles[i], reader, progress, &opts)
+			hashed[i] = checksumFiles[i]
+			compareChecksum(&checksumFiles[i])
+			opts.result(checksumFiles[i])
+			read[i] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i, j := range dups {
+		copyHashed(&checksumFiles[i], hashed[j], progress)
+		compareChecksum(&checksumFiles[i])
+	}
+
+	// The entries not read are done too, missing or listed twice
+	for i, checksumFile := range checksumFiles {
+		if !read[i] {
+			opts.result(checksumFile)
+		}
+	}
+
+	progress.finish()
+
+	return checksumFiles
+}
+
+// isManifestName tells whether an archive member looks like a verification file
+func isManifestName(name string) bool {
+	base := path.Base(name)
+	for _, manifestName := range manifestNames {
+		if base == manifestName {
+			return true
+		}
+	}
+
+	for _, extension := range manifestExtensions {
+		if path.Ext(base) == extension {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walkArchive calls fn with the cleaned name, size and content of every
+// regular file in an archive, in the order they're stored
+func walkArchive(archive string, fn func(string, int64, io.Reader) error) error {
+	if strings.HasSuffix(archive, ".zip") {
+		return walkZip(archive, fn)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReader(file)
+	if !strings.HasSuffix(archive, ".tar") {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		if err := fn(path.Clean(header.Name), header.Size, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// walkZip is walkArchive for zip archives
+func walkZip(archive string, fn func(string, int64, io.Reader) error) error {
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+
+	for _, member := range zipReader.File {
+		if !member.Mode().IsRegular() {
+			continue
+		}
+
+		reader, err := member.Open()
+		if err != nil {
+			return err
+		}
+
+		err = fn(path.Clean(member.Name), int64(member.UncompressedSize64), reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
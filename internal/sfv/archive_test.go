@@ -0,0 +1,76 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyArchiveListedTwice(t *testing.T) {
+	sha256 := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	members := []struct {
+		name    string
+		content string
+	}{
+		{"hello.txt", "hello\n"},
+		{"SHA256SUMS", sha256 + "  hello.txt\n" + sha256 + "  hello.txt\n" +
+			"0000000000000000000000000000000000000000000000000000000000000000  hello.txt\n"},
+	}
+
+	archive := filepath.Join(t.TempDir(), "backup.tar")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := tar.NewWriter(file)
+	for _, member := range members {
+		writer.WriteHeader(&tar.Header{Name: member.name, Mode: 0644, Size: int64(len(member.content))})
+		writer.Write([]byte(member.content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	want := []ChecksumStatus{StatusCheckSumOK, StatusCheckSumOK, StatusCheckSumNoMatch}
+	checksumFiles := VerifyArchiveWithOptions(archive, DefaultOptions())
+	if len(checksumFiles) != len(want) {
+		t.Fatalf("verified %d entries, want %d", len(checksumFiles), len(want))
+	}
+	for i, checksumFile := range checksumFiles {
+		if checksumFile.Status != want[i] {
+			t.Errorf("entry %d is %s, want %s", i, StatusTypeToString(checksumFile.Status), StatusTypeToString(want[i]))
+		}
+	}
+}
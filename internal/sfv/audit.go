@@ -0,0 +1,153 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Unlisted returns the files under Options.BaseDir, or the current directory,
+// which aren't entries of checksumFiles, leaving out the verification file
+// itself. Unexpected files can mean the verification file is incomplete or
+// that files were added behind its back.
+func Unlisted(checksumFiles []ChecksumFile, manifest string, opts Options) []string {
+	root := opts.BaseDir
+	if root == "" {
+		root = "."
+	}
+
+	listed := make(map[string]bool)
+	for _, checksumFile := range checksumFiles {
+		listed[filepath.Clean(checksumFile.Filename)] = true
+	}
+
+	manifestPath, _ := filepath.Abs(manifest)
+
+	unlisted := make([]string, 0)
+	err := walkTree(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			warn("%s: %s", path, err)
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if absPath, _ := filepath.Abs(path); absPath == manifestPath {
+			return nil
+		}
+
+		filename, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if !listed[filename] {
+			unlisted = append(unlisted, filename)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return unlisted
+}
+
+// walkTree works like filepath.Walk, but follows root if it's a symlink to a
+// directory, which filepath.Walk doesn't. Links inside the tree aren't
+// followed. Paths are passed to fn under root as given, not its target.
+func walkTree(root string, fn filepath.WalkFunc) error {
+	resolved := root
+	if info, err := os.Lstat(root); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := filepath.EvalSymlinks(root); err == nil {
+			resolved = target
+		}
+	}
+
+	return filepath.Walk(resolved, func(path string, info os.FileInfo, err error) error {
+		if rel, relErr := filepath.Rel(resolved, path); relErr == nil {
+			path = filepath.Join(root, rel)
+		}
+
+		return fn(path, info, err)
+	})
+}
+
+// expandDirs replaces the directories in files by the files under them, in
+// lexical order. Files which aren't directories are kept as given.
+func expandDirs(files []string, opts *Options) []string {
+	expanded := make([]string, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(opts.path(file))
+		if file == stdinFilename || err != nil || !info.IsDir() {
+			expanded = append(expanded, file)
+			continue
+		}
+
+		root := opts.path(file)
+		err = walkTree(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				warn("%s: %v", path, err)
+				return nil
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			// Walking doesn't follow links, so links to directories show up
+			// as files
+			if info.Mode()&os.ModeSymlink != 0 {
+				if target, err := os.Stat(path); err == nil && target.IsDir() {
+					return nil
+				}
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+
+			expanded = append(expanded, filepath.Join(file, rel))
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return expanded
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateRecursiveSymlinkedRoot(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"data/a.txt", "data/sub/b.txt", "other/c.txt"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The root is a link, links to directories inside it aren't followed
+	if err := os.Symlink("data", filepath.Join(dir, "link")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	if err := os.Symlink(filepath.Join("..", "other"), filepath.Join(dir, "data", "other")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.BaseDir = dir
+	opts.Recursive = true
+
+	checksumFiles := CreateWithOptions(TypeCRC32, []string{"link"}, opts)
+
+	want := []string{filepath.Join("link", "a.txt"), filepath.Join("link", "sub", "b.txt")}
+	if len(checksumFiles) != len(want) {
+		t.Fatalf("hashed %d files, want %v", len(checksumFiles), want)
+	}
+	for i, checksumFile := range checksumFiles {
+		if checksumFile.Filename != want[i] || checksumFile.Status != StatusCheckSumOK {
+			t.Errorf("entry %d is %s with %s, want %s", i, checksumFile.Filename,
+				StatusTypeToString(checksumFile.Status), want[i])
+		}
+	}
+}
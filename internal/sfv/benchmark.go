@@ -0,0 +1,85 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"sort"
+	"time"
+)
+
+type BenchmarkResult struct {
+	ChecksumType ChecksumType
+	Bytes        int64
+	Duration     time.Duration
+}
+
+// Throughput returns the hashed amount of data in MB/s
+func (r BenchmarkResult) Throughput() float64 {
+	return float64(r.Bytes) / r.Duration.Seconds() / (1000 * 1000)
+}
+
+// Benchmark hashes buf repeatedly with every known checksum type for the
+// given duration each. The results are sorted with the fastest type first.
+// Quick fingerprints are left out, they skip the middle of files instead of
+// hashing it, so their speed isn't comparable.
+func Benchmark(buf []byte, duration time.Duration) []BenchmarkResult {
+	results := make([]BenchmarkResult, 0, len(checksumTypes))
+	for _, t := range checksumTypes {
+		if t == TypeQuick {
+			continue
+		}
+		results = append(results, benchmarkType(t, buf, duration))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Throughput() > results[j].Throughput()
+	})
+
+	return results
+}
+
+func benchmarkType(t ChecksumType, buf []byte, duration time.Duration) BenchmarkResult {
+	result := BenchmarkResult{ChecksumType: t}
+
+	// HMAC types are benchmarked with an empty key, which hashes just as fast
+	opts := DefaultOptions()
+	h := newHash(t, &opts)
+
+	start := time.Now()
+	for time.Since(start) < duration {
+		h.Write(buf)
+		result.Bytes += int64(len(buf))
+	}
+	h.Sum(nil)
+	result.Duration = time.Since(start)
+
+	return result
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+)
+
+// VerifyContentWithOptions verifies that the content of each of files has a
+// checksum in listFile, a list of bare checksums like the one written with
+// the checksum line format. Files match by content, not by name, so the
+// list doesn't reveal filenames.
+func VerifyContentWithOptions(listFile string, files []string, opts Options) []ChecksumFile {
+	t, checksums := parseChecksumList(listFile, &opts)
+
+	checksumFiles := statFiles(t, files, &opts)
+	for i, _ := range checksumFiles {
+		checksumFiles[i].FilesizeWant = sizeUnknown
+	}
+
+	progress := newProgress(checksumFiles, &opts)
+	progress.start()
+
+	forEachOrdered(len(checksumFiles), opts.Jobs, func(i int) {
+		calculateChecksum(&checksumFiles[i], progress, &opts)
+		if checksumFiles[i].Status == StatusCheckSumOK {
+			if checksums[checksumFiles[i].Checksum] {
+				checksumFiles[i].ChecksumWant = checksumFiles[i].Checksum
+			} else {
+				checksumFiles[i].Status = StatusCheckSumNoMatch
+			}
+		}
+	}, func(i int) {
+		opts.result(checksumFiles[i])
+	})
+
+	progress.finish()
+
+	return checksumFiles
+}
+
+// parseChecksumList reads a list of bare checksums, skipping blank lines and
+// comments, and returns their type, told by their length unless
+// Options.ForceType is set
+func parseChecksumList(filename string, opts *Options) (ChecksumType, map[string]bool) {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	t := opts.ForceType
+	checksums := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		checksum, ok := decodeChecksum(line, opts.Encoding)
+		if !ok {
+			log.Fatalf("%s:%d: invalid checksum", filename, lineNumber)
+		}
+
+		if t == TypeUnknown {
+			t = typeOfWidth(len(checksum))
+		}
+		if t == TypeUnknown || len(checksum) != typeHexWidth(t) {
+			log.Fatalf("%s:%d: checksums must all be of one type", filename, lineNumber)
+		}
+
+		checksums[strings.ToLower(checksum)] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(checksums) == 0 {
+		log.Fatalf("%s: no checksums found", filename)
+	}
+
+	return t, checksums
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"strings"
+)
+
+// parseCoreutilsLine parses a "hash name" line as written by the coreutils
+// family: GNU and busybox md5sum and friends, and BSD md5 -r. The hash and
+// name are separated by one or more spaces or tabs, the name optionally
+// marked * for binary or ^ for bitwise mode. GNU starts the line with a
+// backslash when the name has escaped backslashes or newlines. The type is
+// told by the length of the hash.
+func parseCoreutilsLine(line string) (ChecksumFile, bool) {
+	var checksumFile ChecksumFile
+
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	end := strings.IndexFunc(line, func(r rune) bool {
+		return !strings.ContainsRune("0123456789abcdefABCDEF", r)
+	})
+	if end <= 0 {
+		return checksumFile, false
+	}
+	checksum := line[:end]
+
+	name := strings.TrimLeft(line[end:], " \t")
+	if len(name) == len(line[end:]) {
+		return checksumFile, false
+	}
+
+	if strings.HasPrefix(name, "*") || strings.HasPrefix(name, "^") {
+		name = name[1:]
+	}
+	if name == "" {
+		return checksumFile, false
+	}
+
+	if escaped {
+		name = unescapeCoreutilsName(name)
+	}
+
+	checksumFile.ChecksumType = typeOfWidth(len(checksum))
+	checksumFile.Filename     = name
+	checksumFile.ChecksumWant = checksum
+
+	return checksumFile, checksumFile.ChecksumType != TypeUnknown
+}
+
+// unescapeCoreutilsName undoes GNU's escaping of backslashes and newlines
+func unescapeCoreutilsName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+1 < len(name) {
+			switch name[i+1] {
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			}
+		}
+
+		b.WriteByte(name[i])
+	}
+
+	return b.String()
+}
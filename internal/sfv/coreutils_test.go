@@ -0,0 +1,82 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCoreutilsFixtures(t *testing.T) {
+	md5    := "b1946ac92492d2347c6235b4d2611184"
+	sha1   := "f572d396fae9206628714fb2ce00f72e94f2258f"
+	sha256 := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+	tests := []struct {
+		fixture string
+		want    []entry
+	}{
+		// GNU md5sum, text and binary mode and escaped names
+		{"gnu.md5", []entry{
+			{TypeMD5, "hello.txt", md5},
+			{TypeMD5, "binary.bin", md5},
+			{TypeMD5, "back\\slash.txt", md5},
+			{TypeMD5, "new\nline.txt", md5},
+		}},
+		// GNU sha256sum --tag
+		{"gnu-tag.sha256", []entry{
+			{TypeSHA256, "hello.txt", sha256},
+			{TypeSHA256, "sub/dir.txt", sha256},
+		}},
+		// BSD md5 and md5 -r
+		{"bsd.md5", []entry{
+			{TypeMD5, "hello.txt", md5},
+		}},
+		{"bsd-r.md5", []entry{
+			{TypeMD5, "hello.txt", md5},
+		}},
+		// busybox sha1sum
+		{"busybox.sha1", []entry{
+			{TypeSHA1, "hello.txt", sha1},
+			{TypeSHA1, "sub/dir.txt", sha1},
+		}},
+		// Tabs and several spaces before the name
+		{"mixed.sha1", []entry{
+			{TypeSHA1, "hello.txt", sha1},
+			{TypeSHA1, "binary.bin", sha1},
+		}},
+	}
+
+	for _, test := range tests {
+		fixture := filepath.Join("coreutils", test.fixture)
+		checkEntries(t, fixture, parseFixture(t, fixture, DefaultOptions()), test.want)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// decompressors are the commands decompressing the formats without a
+// reader in the standard library by file extension, reading the compressed
+// data from standard input and writing the content to standard output
+var decompressors = map[string][]string{
+	".xz":  {"xz", "--decompress", "--stdout"},
+	".zst": {"zstd", "--decompress", "--stdout", "--quiet"},
+}
+
+// isCompressed tells whether a file is decompressed before hashing when
+// Options.Decompress is set, which are gzip, xz and zstd files
+func isCompressed(filename string) bool {
+	ext := filepath.Ext(filename)
+	return ext == ".gz" || decompressors[ext] != nil
+}
+
+// newDecompressor returns a reader of the decompressed content of reader,
+// compressed in the format told by the extension of filename
+func newDecompressor(filename string, reader io.Reader) (io.ReadCloser, error) {
+	ext := filepath.Ext(filename)
+	if ext == ".gz" {
+		return gzip.NewReader(reader)
+	}
+
+	args := decompressors[ext]
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = reader
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandReader{stdout, cmd, stderr, false}, nil
+}
+
+// commandReader reads the output of a decompressing command, which only
+// tells whether the data was corrupt when it exits
+type commandReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	waited bool
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		if waitErr := r.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// Close stops the command if it's still running, like when hashing was
+// interrupted
+func (r *commandReader) Close() error {
+	if r.waited {
+		return nil
+	}
+
+	r.cmd.Process.Kill()
+	r.ReadCloser.Close()
+	r.wait()
+	return nil
+}
+
+func (r *commandReader) wait() error {
+	r.waited = true
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v: %s", r.cmd.Args[0], err, strings.TrimSpace(r.stderr.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	opts := DefaultOptions()
+	opts.BaseDir = "testdata"
+	opts.Decompress = true
+
+	for _, filename := range []string{"hello.txt.gz", "hello.txt.xz", "hello.txt.zst"} {
+		if args, ok := decompressors[filepath.Ext(filename)]; ok {
+			if _, err := exec.LookPath(args[0]); err != nil {
+				t.Logf("%s: %s not installed, skipped", filename, args[0])
+				continue
+			}
+		}
+
+		checksumFiles := CreateWithOptions(TypeCRC32, []string{filename}, opts)
+		if checksumFiles[0].Status != StatusCheckSumOK || checksumFiles[0].Checksum != "363a3020" {
+			t.Errorf("%s: %s %s, want the CRC32 of hello.txt", filename, StatusTypeToString(checksumFiles[0].Status), checksumFiles[0].Checksum)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"path/filepath"
+)
+
+// duplicates returns for each entry the index of an earlier entry hashing
+// the same file with the same algorithm, or -1 if there is none, so a file
+// listed several times is only read once. Paths are compared once resolved,
+// so ./a, a and a symlink to a are the same file.
+func duplicates(checksumFiles []ChecksumFile, opts *Options) []int {
+	type key struct {
+		path         string
+		checksumType ChecksumType
+	}
+
+	first := make(map[key]int)
+	dups  := make([]int, len(checksumFiles))
+	for i, checksumFile := range checksumFiles {
+		dups[i] = -1
+		if checksumFile.Status != StatusOK || checksumFile.Filename == stdinFilename {
+			continue
+		}
+
+		path := opts.path(checksumFile.Filename)
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+
+		k := key{path, checksumFile.ChecksumType}
+		if j, ok := first[k]; ok {
+			dups[i] = j
+		} else {
+			first[k] = i
+		}
+	}
+
+	return dups
+}
+
+// copyHashed gives checksumFile the result of hashing the entry it
+// duplicates, as it was before being compared, and counts it as read
+func copyHashed(checksumFile *ChecksumFile, hashed ChecksumFile, progress *progress) {
+	if checksumFile.Status != StatusOK {
+		return
+	}
+
+	checksumFile.Status     = hashed.Status
+	checksumFile.Checksum   = hashed.Checksum
+	checksumFile.Filesize   = hashed.Filesize
+	checksumFile.BadRegions = hashed.BadRegions
+
+	if hashed.Filesize != sizeUnknown {
+		progress.skip(hashed.Filesize, checksumFile.Filename)
+	}
+	progress.done(checksumFile.Filename)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"hash"
+)
+
+// ed2kChunkSize is the size of the chunks ed2k hashes separately, 9500 KiB
+const ed2kChunkSize = 9500 * 1024
+
+// ed2k is the eDonkey2000 hash, the MD4 of the data if it's shorter than a
+// chunk, otherwise the MD4 of the MD4s of each chunk. Data of an exact
+// multiple of the chunk size ends with the MD4 of an empty chunk, like the
+// original client and rhash do.
+type ed2k struct {
+	chunk   hash.Hash
+	written int
+	hashes  []byte
+}
+
+func newED2K() hash.Hash {
+	return &ed2k{chunk: newMD4()}
+}
+
+func (d *ed2k) Reset() {
+	d.chunk.Reset()
+	d.written = 0
+	d.hashes = d.hashes[:0]
+}
+
+func (d *ed2k) Size() int {
+	return d.chunk.Size()
+}
+
+func (d *ed2k) BlockSize() int {
+	return d.chunk.BlockSize()
+}
+
+func (d *ed2k) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		part := p
+		if len(part) > ed2kChunkSize-d.written {
+			part = part[:ed2kChunkSize-d.written]
+		}
+
+		d.chunk.Write(part)
+		d.written += len(part)
+		p = p[len(part):]
+
+		if d.written == ed2kChunkSize {
+			d.hashes = d.chunk.Sum(d.hashes)
+			d.chunk.Reset()
+			d.written = 0
+		}
+	}
+
+	return n, nil
+}
+
+func (d *ed2k) Sum(in []byte) []byte {
+	if len(d.hashes) == 0 {
+		return d.chunk.Sum(in)
+	}
+
+	root := newMD4()
+	root.Write(d.hashes)
+	root.Write(d.chunk.Sum(nil))
+
+	return root.Sum(in)
+}
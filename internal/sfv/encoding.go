@@ -0,0 +1,104 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+)
+
+// Encoding is how checksums are written in verification files. Checksums
+// are always hex while hashing and comparing, other encodings only apply
+// when writing and parsing.
+type Encoding int
+
+const (
+	EncodingHex Encoding = iota
+	EncodingBase64
+)
+
+// base64LineParsers are tried in order when parsing a checksum line with
+// EncodingBase64. Checksums after the filename are only taken to be CRC32,
+// like in the hex "name hash" format, other types are told by their length.
+var base64LineParsers = []lineParser{
+	// sha384-<base64> name
+	{TypeUnknown, reIntegrityLine, 0, 2, 1, true},
+	// name hash
+	{TypeCRC32, regexp.MustCompile(`^([\w\pL\pM\pN\./-]+)[\s]+([A-Za-z0-9+/]{6}==)$`), 0, 1, 2, false},
+	// ALGORITHM (name) = hash
+	{TypeUnknown, regexp.MustCompile(`^([\w-]+) \(([\w\pL\pM\pN\./-]+)\) = ([\w+/]+=*)$`), 1, 2, 3, false},
+	// hash  name
+	{TypeUnknown, regexp.MustCompile(`^([\w+/]+=*)[\s]+([\w\pL\pM\pN\./-]+)$`), 0, 2, 1, false},
+}
+
+// encodeChecksum converts a hex checksum to encoding
+func encodeChecksum(checksum string, encoding Encoding) string {
+	if encoding != EncodingBase64 {
+		return checksum
+	}
+
+	digest, err := hex.DecodeString(checksum)
+	if err != nil {
+		return checksum
+	}
+
+	return base64.StdEncoding.EncodeToString(digest)
+}
+
+// typeOfChecksum returns the type told by the width of s if it's a checksum
+// in encoding, or TypeUnknown if it isn't one
+func typeOfChecksum(s string, encoding Encoding) ChecksumType {
+	checksum, ok := decodeChecksum(s, encoding)
+	if !ok {
+		return TypeUnknown
+	}
+
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return TypeUnknown
+	}
+
+	return typeOfWidth(len(checksum))
+}
+
+// decodeChecksum converts a checksum in encoding to hex
+func decodeChecksum(checksum string, encoding Encoding) (string, bool) {
+	if encoding != EncodingBase64 {
+		return checksum, true
+	}
+
+	digest, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil {
+		return "", false
+	}
+
+	return hex.EncodeToString(digest), true
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"regexp"
+)
+
+var reManifestHash = regexp.MustCompile(`^; manifest-sha256 ([0-9a-f]{64})$`)
+
+// manifestHasher hashes everything written through it, so a hash of the
+// manifest can be appended once the entries are written
+type manifestHasher struct {
+	checksumWriter
+	hash hash.Hash
+}
+
+func (w *manifestHasher) Write(p []byte) (int, error) {
+	w.hash.Write(p)
+	return w.checksumWriter.Write(p)
+}
+
+func (w *manifestHasher) WriteString(s string) (int, error) {
+	io.WriteString(w.hash, s)
+	return w.checksumWriter.WriteString(s)
+}
+
+// writeManifest writes the entries, followed by a hash of the manifest when
+// Options.WriteManifestHash is set. The hash also covers preceding, the
+// lines already in a file which is appended to.
+func writeManifest(file checksumWriter, preceding io.Reader, checksumFiles []ChecksumFile, opts *Options) error {
+	if !opts.WriteManifestHash {
+		return writeChecksumFiles(file, checksumFiles, opts)
+	}
+
+	hasher := &manifestHasher{file, sha256.New()}
+	if preceding != nil {
+		if err := hasher.precede(preceding); err != nil {
+			return err
+		}
+	}
+
+	if err := writeChecksumFiles(hasher, checksumFiles, opts); err != nil {
+		return err
+	}
+
+	return hasher.writeHash()
+}
+
+// precede adds the lines of r to the hash without writing them, as they're
+// already in the file
+func (w *manifestHasher) precede(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		io.WriteString(w.hash, scanner.Text()+"\n")
+	}
+
+	return scanner.Err()
+}
+
+// writeHash writes the hash of everything written so far, which isn't
+// hashed itself
+func (w *manifestHasher) writeHash() error {
+	_, err := w.checksumWriter.WriteString(fmt.Sprintf("; manifest-sha256 %s\n", hex.EncodeToString(w.hash.Sum(nil))))
+	return err
+}
+
+// manifestVerifier checks the manifest hashes of a file as it's parsed, each
+// against the lines preceding it
+type manifestVerifier struct {
+	hash      hash.Hash
+	found     bool
+	uncovered int
+}
+
+func newManifestVerifier() *manifestVerifier {
+	return &manifestVerifier{hash: sha256.New()}
+}
+
+// line checks line if it's a manifest hash, and adds it to the hash of the
+// lines read so far
+func (v *manifestVerifier) line(line string, filename string, lineNumber int, opts *Options) {
+	if matches := reManifestHash.FindStringSubmatch(line); matches != nil {
+		if hex.EncodeToString(v.hash.Sum(nil)) != matches[1] {
+			if opts.Strict {
+				log.Fatalf("%s:%d: manifest hash doesn't match, the file was modified", filename, lineNumber)
+			}
+			warn("%s:%d: manifest hash doesn't match, the file was modified", filename, lineNumber)
+		}
+
+		v.found = true
+		v.uncovered = 0
+	} else if line != "" {
+		v.uncovered++
+	}
+
+	io.WriteString(v.hash, line+"\n")
+}
+
+// finish warns about lines added after the last manifest hash, which aren't
+// covered by it
+func (v *manifestVerifier) finish(filename string, opts *Options) {
+	if !v.found || v.uncovered == 0 {
+		return
+	}
+
+	if opts.Strict {
+		log.Fatalf("%s: %d lines after the manifest hash aren't covered by it", filename, v.uncovered)
+	}
+	warn("%s: %d lines after the manifest hash aren't covered by it", filename, v.uncovered)
+}
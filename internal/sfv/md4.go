@@ -0,0 +1,147 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// md4 is the MD4 hash of RFC 1320, which ed2k is built on. It's too weak to
+// be offered as a checksum type of its own.
+type md4 struct {
+	s      [4]uint32
+	block  [64]byte
+	nblock int
+	length uint64
+}
+
+func newMD4() hash.Hash {
+	d := &md4{}
+	d.Reset()
+	return d
+}
+
+func (d *md4) Reset() {
+	d.s = [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	d.nblock = 0
+	d.length = 0
+}
+
+func (d *md4) Size() int {
+	return 16
+}
+
+func (d *md4) BlockSize() int {
+	return 64
+}
+
+func (d *md4) Write(p []byte) (int, error) {
+	n := len(p)
+	d.length += uint64(n)
+
+	if d.nblock > 0 {
+		copied := copy(d.block[d.nblock:], p)
+		d.nblock += copied
+		p = p[copied:]
+		if d.nblock < 64 {
+			return n, nil
+		}
+		d.compress(d.block[:])
+		d.nblock = 0
+	}
+
+	for len(p) >= 64 {
+		d.compress(p[:64])
+		p = p[64:]
+	}
+	d.nblock = copy(d.block[:], p)
+
+	return n, nil
+}
+
+func (d *md4) Sum(in []byte) []byte {
+	// Padding changes the state, so finish a copy
+	c := *d
+
+	var padding [72]byte
+	padding[0] = 0x80
+	padLength := 56 - int(c.length%64)
+	if padLength <= 0 {
+		padLength += 64
+	}
+	binary.LittleEndian.PutUint64(padding[padLength:], c.length<<3)
+	c.Write(padding[:padLength+8])
+
+	var digest [16]byte
+	for i, s := range c.s {
+		binary.LittleEndian.PutUint32(digest[i*4:], s)
+	}
+
+	return append(in, digest[:]...)
+}
+
+// md4Shifts are the rotations of each round, per step modulo 4
+var md4Shifts = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+
+// md4Order3 is the order of the words in the third round
+var md4Order3 = [16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}
+
+func (d *md4) compress(p []byte) {
+	var x [16]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(p[i*4:])
+	}
+
+	a, b, c, e := d.s[0], d.s[1], d.s[2], d.s[3]
+
+	for i := 0; i < 16; i++ {
+		f := (b & c) | (^b & e)
+		a, b, c, e = e, bits.RotateLeft32(a+f+x[i], md4Shifts[0][i%4]), b, c
+	}
+
+	for i := 0; i < 16; i++ {
+		f := (b & c) | (b & e) | (c & e)
+		k := (i%4)*4 + i/4
+		a, b, c, e = e, bits.RotateLeft32(a+f+x[k]+0x5a827999, md4Shifts[1][i%4]), b, c
+	}
+
+	for i := 0; i < 16; i++ {
+		f := b ^ c ^ e
+		a, b, c, e = e, bits.RotateLeft32(a+f+x[md4Order3[i]]+0x6ed9eba1, md4Shifts[2][i%4]), b, c
+	}
+
+	d.s[0] += a
+	d.s[1] += b
+	d.s[2] += c
+	d.s[3] += e
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MergeWithOptions combines the entries of verification files into one list
+// sorted by filename, ready to be written, without hashing anything. Entries
+// listed more than once are kept once. Entries listed with different
+// checksums keep the first checksum with a warning, or stop with
+// Options.Strict since it can't be told which one is right.
+func MergeWithOptions(files []string, opts Options) []ChecksumFile {
+	merged := make([]ChecksumFile, 0)
+	indexes := make(map[string]int)
+
+	for _, filename := range files {
+		file, err := os.Open(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		checksumFiles := parseSfvReader(file, filename, &opts)
+		file.Close()
+
+		for _, checksumFile := range checksumFiles {
+			checksumFile.Filename = mapFilename(checksumFile.Filename, &opts)
+			checksumFile.Status   = StatusCheckSumOK
+			checksumFile.Checksum = strings.ToLower(checksumFile.ChecksumWant)
+			checksumFile.Filesize = checksumFile.FilesizeWant
+			checksumFile.Metadata = checksumFile.MetadataWant
+			checksumFile.Xattrs   = checksumFile.XattrsWant
+
+			i, ok := indexes[checksumFile.Filename]
+			if !ok {
+				indexes[checksumFile.Filename] = len(merged)
+				merged = append(merged, checksumFile)
+				continue
+			}
+
+			if merged[i].ChecksumType != checksumFile.ChecksumType || merged[i].Checksum != checksumFile.Checksum {
+				if opts.Strict {
+					log.Fatalf("%s: %s conflicts with an earlier checksum", filename, checksumFile.Filename)
+				}
+				warn("%s: %s conflicts with an earlier checksum, keeping the first", filename, checksumFile.Filename)
+				continue
+			}
+
+			if merged[i].Filesize == sizeUnknown {
+				merged[i].Filesize = checksumFile.Filesize
+			}
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Filename < merged[j].Filename })
+
+	return merged
+}
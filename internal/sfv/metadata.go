@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"fmt"
+	"os"
+)
+
+// Metadata is the mode and owner of a file, recorded to notice changes
+// which don't touch the content
+type Metadata struct {
+	// Mode holds the Unix permission, setuid, setgid and sticky bits
+	Mode uint32
+
+	// Uid and Gid are the owner, or -1 where files have no numeric owner
+	Uid int
+	Gid int
+}
+
+// String formats the metadata the way it's written in comments
+func (m Metadata) String() string {
+	return fmt.Sprintf("mode %04o uid %d gid %d", m.Mode, m.Uid, m.Gid)
+}
+
+// unixMode converts the permission bits of a FileMode to their Unix values
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+
+	return bits
+}
+
+// metadataMatches tells whether a file still has the metadata it was
+// recorded with. An owner which wasn't recorded or can't be read matches.
+func metadataMatches(want *Metadata, got *Metadata) bool {
+	if want.Mode != got.Mode {
+		return false
+	}
+
+	if want.Uid >= 0 && got.Uid >= 0 && (want.Uid != got.Uid || want.Gid != got.Gid) {
+		return false
+	}
+
+	return true
+}
+
+// checkMetadata marks a file whose content matches but whose metadata or
+// extended attributes don't. Attributes which can't be read match.
+func checkMetadata(checksumFile *ChecksumFile) {
+	if checksumFile.Status != StatusCheckSumOK {
+		return
+	}
+
+	if checksumFile.MetadataWant != nil && checksumFile.Metadata != nil &&
+		!metadataMatches(checksumFile.MetadataWant, checksumFile.Metadata) {
+		checksumFile.Status = StatusMetadataChanged
+	}
+
+	if checksumFile.XattrsWant != "" && checksumFile.Xattrs != "" && checksumFile.Xattrs != checksumFile.XattrsWant {
+		checksumFile.Status = StatusMetadataChanged
+	}
+}
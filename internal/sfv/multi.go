@@ -0,0 +1,130 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"hash"
+)
+
+// typeMulti is the checksum type of files hashed with every type of
+// Options.Types at once, the checksums of which are concatenated until
+// they're split by CreateTypesWithOptions
+const typeMulti ChecksumType = -1
+
+// CreateTypesWithOptions works like CreateWithOptions with a checksum of each
+// of types, reading every file once. The entries of each type are returned
+// in the order of types.
+func CreateTypesWithOptions(types []ChecksumType, files []string, opts Options) [][]ChecksumFile {
+	opts.Types = types
+	checksumFiles := create(typeMulti, files, &opts, nil)
+
+	typed := make([][]ChecksumFile, len(types))
+	offset := 0
+	for i, t := range types {
+		width := typeHexWidth(t)
+
+		typed[i] = make([]ChecksumFile, len(checksumFiles))
+		for j, checksumFile := range checksumFiles {
+			checksumFile.ChecksumType = t
+			if checksumFile.Status == StatusCheckSumOK {
+				checksumFile.Checksum = checksumFile.Checksum[offset : offset+width]
+			}
+			if t == TypeHMACSHA256 && len(opts.HMACKey) == 0 {
+				checksumFile.Status   = StatusMissingKey
+				checksumFile.Checksum = ""
+			}
+
+			typed[i][j] = checksumFile
+		}
+
+		offset += width
+	}
+
+	return typed
+}
+
+// multiHash writes to several hashes at once, its sum is their sums
+// concatenated
+type multiHash struct {
+	hashes []hash.Hash
+	sizes  []int
+}
+
+func newMultiHash(types []ChecksumType, opts *Options) *multiHash {
+	h := &multiHash{}
+	for _, t := range types {
+		h.hashes = append(h.hashes, newHash(t, opts))
+		h.sizes = append(h.sizes, typeHexWidth(t)/2)
+	}
+
+	return h
+}
+
+func (h *multiHash) Write(p []byte) (int, error) {
+	for _, hasher := range h.hashes {
+		hasher.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// Sum appends each sum zero padded to the size of its type, so they can be
+// split apart again
+func (h *multiHash) Sum(b []byte) []byte {
+	for i, hasher := range h.hashes {
+		sum := hasher.Sum(nil)
+		for padding := h.sizes[i] - len(sum); padding > 0; padding-- {
+			b = append(b, 0)
+		}
+		b = append(b, sum...)
+	}
+
+	return b
+}
+
+func (h *multiHash) Reset() {
+	for _, hasher := range h.hashes {
+		hasher.Reset()
+	}
+}
+
+func (h *multiHash) Size() int {
+	size := 0
+	for _, s := range h.sizes {
+		size += s
+	}
+
+	return size
+}
+
+func (h *multiHash) BlockSize() int {
+	return h.hashes[0].BlockSize()
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// VerifyNamedWithOptions verifies each of files against the checksum in its
+// name, the first group of re matched against the base name, like
+// re `\.([0-9a-f]{64})\.iso$` for file.<sha256>.iso. The type is
+// Options.ForceType if set, or else told by the length of the checksum.
+// Files whose name has no checksum get StatusNoHashInName.
+func VerifyNamedWithOptions(files []string, re *regexp.Regexp, opts Options) []ChecksumFile {
+	checksumFiles := make([]ChecksumFile, len(files))
+	for i, file := range files {
+		checksumFiles[i] = ChecksumFile{TypeUnknown, StatusNoHashInName, file, 0, "", "", sizeUnknown, false, nil, nil, "", "", nil}
+
+		matches := re.FindStringSubmatch(filepath.Base(file))
+		if len(matches) < 2 || matches[1] == "" {
+			continue
+		}
+
+		checksumType := opts.ForceType
+		if checksumType == TypeUnknown {
+			checksumType = typeOfWidth(len(matches[1]))
+		}
+		if checksumType == TypeUnknown {
+			warn("%s: no algorithm has checksums of %d characters like %s", file, len(matches[1]), matches[1])
+			continue
+		}
+
+		checksumFiles[i].ChecksumType = checksumType
+		checksumFiles[i].ChecksumWant = strings.ToLower(matches[1])
+
+		verifyChecksumFile(&checksumFiles[i], &opts)
+		checkFilesize(&checksumFiles[i])
+	}
+
+	hashEntries(checksumFiles, &opts)
+
+	return checksumFiles
+}
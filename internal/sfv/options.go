@@ -0,0 +1,321 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"io"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"golang.org/x/text/encoding"
+)
+
+// defaultConcurrency is the number of files stat'ed at once unless set
+const defaultConcurrency = 16
+
+// defaultBufferSize is the size of the buffer files are read with unless set
+const defaultBufferSize = 64 * 1024
+
+// PathStyle is how Create records the filenames of the files it's given
+type PathStyle int
+
+const (
+	// PathAsGiven records filenames exactly as given
+	PathAsGiven PathStyle = iota
+	// PathRelative records filenames relative to Options.RelativeTo
+	PathRelative
+	// PathAbsolute records absolute filenames
+	PathAbsolute
+)
+
+// Options controls how files are created, verified and written
+type Options struct {
+	// Concurrency is the number of files stat'ed at once
+	Concurrency int
+
+	// Jobs is the number of files hashed at once. Results are still reported
+	// in the order the files were given.
+	Jobs int
+
+	// BufferSize is the size of the buffer files are read with. Larger
+	// buffers mean fewer reads, which helps on slow or network file
+	// systems, at the cost of memory.
+	BufferSize int
+
+	// ProgressWriter receives newline-delimited JSON progress events
+	ProgressWriter io.Writer
+
+	// ProgressRefreshRate is how often the progress bar is redrawn, or a
+	// line is printed with ProgressStyleLog
+	ProgressRefreshRate time.Duration
+
+	// ProgressWidth is the width of the progress bar in columns, the width
+	// of the terminal when 0
+	ProgressWidth int
+
+	// ProgressUnit is what the progress bar counts
+	ProgressUnit ProgressUnit
+
+	// ProgressStyle is how progress is shown, a redrawn bar unless set
+	ProgressStyle ProgressStyle
+
+	// StatusFile is rewritten every few seconds with the files and bytes
+	// done, the file being read and the throughput when set, for watching a
+	// run from elsewhere
+	StatusFile string
+
+	// ProgressFunc is called as each file is read with the bytes read of it
+	// so far and its size, which is -1 if unknown. It's called often, from
+	// the goroutines hashing, so it should return quickly and be safe to
+	// call concurrently when Jobs is more than one.
+	ProgressFunc func(filename string, done, total int64)
+
+	// ResultFunc is called with each entry as soon as it's done, before the
+	// whole run is. Entries come in the order given, except from archives
+	// where they come in the order stored. It's never called concurrently.
+	ResultFunc func(ChecksumFile)
+
+	// TimingsWriter receives a line with the time since the run started, the
+	// size, hashing time and throughput of each file hashed, when set
+	TimingsWriter io.Writer
+
+	// Stop interrupts hashing when closed. The files not yet hashed, and the
+	// one being hashed, get StatusInterrupted while the results of those
+	// already hashed are kept.
+	Stop <-chan struct{}
+
+	// BaseDir is the directory relative filenames are opened from, the
+	// current directory when empty. Filenames are written as given.
+	BaseDir string
+
+	// PathStyle is how Create records filenames. With PathRelative they're
+	// relative to RelativeTo, or BaseDir if that's empty.
+	PathStyle  PathStyle
+	RelativeTo string
+
+	// Recursive replaces directories given to Create by the files under
+	// them. A directory given which is a symlink is followed, symlinks to
+	// directories inside it aren't.
+	Recursive bool
+
+	// StdinName is the filename written for standard input, which is read
+	// when "-" is given as a file to Create
+	StdinName string
+
+	// Since leaves out files given to Create which were last modified
+	// before it, unless it's the zero time
+	Since time.Time
+
+	// InputEncoding is the character encoding verification files are read
+	// in, like Windows-1252 or Shift JIS for old files. They're read as
+	// UTF-8 if nil.
+	InputEncoding encoding.Encoding
+
+	// ArchiveManifest is the name of the archive member holding the
+	// verification file when verifying an archive, the first member which
+	// looks like one if empty
+	ArchiveManifest string
+
+	// Strict turns warnings about malformed verification files into errors
+	Strict bool
+
+	// ForceType overrides the checksum type of every parsed line
+	ForceType ChecksumType
+
+	// RequirePresent is the percentage of the files in a verification file
+	// which must exist for any of them to be hashed
+	RequirePresent float64
+
+	// SampleSize or, if set, SamplePercent limits verification to that many
+	// entries picked at random, using SampleSeed
+	SampleSize    int
+	SamplePercent float64
+	SampleSeed    int64
+
+	// ListedFunc is called with every entry of the verification file before
+	// a sample is picked, when set
+	ListedFunc func([]ChecksumFile)
+
+	// TrimPrefix is removed from, and AddPrefix then added to, filenames
+	// read from verification files
+	TrimPrefix string
+	AddPrefix  string
+
+	// RehashOnMismatch reads files which don't match their checksum a second
+	// time, and only reports a mismatch if both reads give the same checksum
+	RehashOnMismatch bool
+
+	// JoinParts verifies entries which don't exist as a file, but are split
+	// into parts named like name.001, name.002 and so on, by the parts read
+	// as one file
+	JoinParts bool
+
+	// NoSymlinks skips verifying entries which are symlinks
+	NoSymlinks bool
+
+	// Decompress makes compressed files be hashed by their decompressed
+	// content, matching checksums taken before they were compressed. Gzip
+	// is read directly, xz and zstd through the xz and zstd commands.
+	Decompress bool
+
+	// HMACKey is the secret used for HMAC checksum types
+	HMACKey []byte
+
+	// Append adds entries to the end of the file written instead of
+	// replacing it, entries already in the file are left out
+	Append bool
+
+	// WriteHeader controls whether written files start with a comment
+	// telling which version generated them and when
+	WriteHeader bool
+
+	// HeaderTimeFormat is the layout of the header timestamp, the timestamp
+	// is left out when empty
+	HeaderTimeFormat string
+
+	// HeaderLocalTime makes the header timestamp use local time instead of UTC
+	HeaderLocalTime bool
+
+	// WritePathHashes adds a comment with a hash of each path, which lets
+	// verify tell renamed entries from new ones
+	WritePathHashes bool
+
+	// WriteMetadata adds a comment with the mode and owner of each file,
+	// which verify reports as StatusMetadataChanged when they differ
+	WriteMetadata bool
+
+	// WriteXattrs adds a comment with a hash of the extended attributes of
+	// each file, verified like WriteMetadata. Files on systems or
+	// filesystems without extended attributes are left without one.
+	WriteXattrs bool
+
+	// Types are the checksum types CreateTypesWithOptions hashes each file
+	// with, set by it
+	Types []ChecksumType
+
+	// ResumeState is a file the state of hashing each file is saved to
+	// every few seconds when set. Hashing a file which was interrupted
+	// resumes from its saved state if the file is unchanged. Only hashes which can
+	// marshal their state resume, like MD5, SHA1, SHA256 and SHA512.
+	ResumeState string
+
+	// SkipReadErrors zero-fills the blocks of files which can't be read and
+	// goes on, giving them StatusPartialRead, instead of failing them
+	SkipReadErrors bool
+
+	// OnlyTypes limits verifying to entries of these checksum types when
+	// set, the others get StatusTypeSkipped
+	OnlyTypes []ChecksumType
+
+	// SignatureFile is a detached minisign or GPG signature of the manifest,
+	// checked before it's parsed when set
+	SignatureFile string
+
+	// SignatureKey is the minisign public key file or the GPG keyring the
+	// signature is checked with, the tool's default when empty
+	SignatureKey string
+
+	// WriteManifestHash appends a comment with a hash of the lines before it,
+	// which parsing checks to notice a modified file
+	WriteManifestHash bool
+
+	// Encoding is how checksums are written and parsed, hex unless set
+	Encoding Encoding
+
+	// LineTemplate formats each written line instead of the default format
+	// of the checksum type when set, see ParseLineFormat
+	LineTemplate *template.Template
+}
+
+// DefaultOptions returns the options used by Create, Verify and WriteToFile
+func DefaultOptions() Options {
+	return Options{
+		Concurrency:         defaultConcurrency,
+		Jobs:                1,
+		BufferSize:          defaultBufferSize,
+		ProgressRefreshRate: 200 * time.Millisecond,
+		StdinName:           "-",
+		ForceType:           TypeUnknown,
+		WriteHeader:         true,
+		HeaderTimeFormat:    time.RFC3339,
+	}
+}
+
+// path returns where filename is opened from
+func (o *Options) path(filename string) string {
+	if o.BaseDir == "" || filepath.IsAbs(filename) {
+		return longPath(filename)
+	}
+
+	return longPath(filepath.Join(o.BaseDir, filename))
+}
+
+// result passes checksumFile on to ResultFunc if set
+func (o *Options) result(checksumFile ChecksumFile) {
+	if o.ResultFunc != nil {
+		o.ResultFunc(checksumFile)
+	}
+}
+
+// recordedPath returns the filename Create records for filename, following
+// PathStyle. Filenames which can't be made relative are recorded absolute.
+func (o *Options) recordedPath(filename string) string {
+	if o.PathStyle == PathAsGiven {
+		return filename
+	}
+
+	if o.BaseDir != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(o.BaseDir, filename)
+	}
+
+	absPath, err := filepath.Abs(filename)
+	if err != nil || o.PathStyle == PathAbsolute {
+		return absPath
+	}
+
+	base := o.RelativeTo
+	if base == "" {
+		base = o.BaseDir
+	}
+
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return absPath
+	}
+
+	relPath, err := filepath.Rel(absBase, absPath)
+	if err != nil {
+		return absPath
+	}
+
+	return relPath
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// par2Magic starts every packet of a PAR2 file
+var par2Magic = []byte("PAR2\x00PKT")
+
+// par2FileDesc is the type of the packets describing a file
+var par2FileDesc = []byte("PAR 2.0\x00FileDesc")
+
+// par2HeaderSize is the size of a packet header, the packet MD5 covers
+// everything after the first 32 bytes
+const par2HeaderSize = 64
+
+// isPar2 tells whether a file is a PAR2 file, read for its file listing
+func isPar2(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".par2")
+}
+
+// parsePar2Reader returns an MD5 entry for each file described in a PAR2
+// file. Only the listing is read, the recovery data is skipped, so damaged
+// files can be found but not repaired.
+func parsePar2Reader(reader io.Reader, filename string) []ChecksumFile {
+	checksumFiles := make([]ChecksumFile, 0)
+
+	// Packets are repeated in a recovery set for redundancy
+	fileIDs := make(map[string]bool)
+
+	buffered := bufio.NewReader(reader)
+	header := make([]byte, par2HeaderSize)
+	for {
+		if _, err := io.ReadFull(buffered, header); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatalf("%s: %s", filename, err)
+		}
+
+		if !bytes.Equal(header[:8], par2Magic) {
+			warn("%s: not a PAR2 packet, stopped reading", filename)
+			break
+		}
+
+		length := binary.LittleEndian.Uint64(header[8:16])
+		if length < par2HeaderSize || length%4 != 0 {
+			warn("%s: invalid PAR2 packet length %d, stopped reading", filename, length)
+			break
+		}
+
+		if !bytes.Equal(header[48:64], par2FileDesc) {
+			if _, err := io.CopyN(ioutil.Discard, buffered, int64(length-par2HeaderSize)); err != nil {
+				log.Fatalf("%s: %s", filename, err)
+			}
+			continue
+		}
+
+		body := make([]byte, length-par2HeaderSize)
+		if _, err := io.ReadFull(buffered, body); err != nil {
+			log.Fatalf("%s: %s", filename, err)
+		}
+
+		hasher := md5.New()
+		hasher.Write(header[32:])
+		hasher.Write(body)
+		if !bytes.Equal(hasher.Sum(nil), header[16:32]) {
+			warn("%s: damaged PAR2 packet skipped", filename)
+			continue
+		}
+
+		// File ID, MD5 of the file, MD5 of its first 16 KiB, length and name
+		if len(body) < 56 {
+			warn("%s: short PAR2 file description skipped", filename)
+			continue
+		}
+
+		fileID := string(body[:16])
+		if fileIDs[fileID] {
+			continue
+		}
+		fileIDs[fileID] = true
+
+		checksumFile := ChecksumFile{TypeMD5, StatusUnknown, "", 0, "", "", 0, false, nil, nil, "", "", nil}
+		checksumFile.ChecksumWant = hex.EncodeToString(body[16:32])
+		checksumFile.FilesizeWant = int64(binary.LittleEndian.Uint64(body[48:56]))
+		checksumFile.Filename     = string(bytes.TrimRight(body[56:], "\x00"))
+		checksumFiles = append(checksumFiles, checksumFile)
+	}
+
+	return checksumFiles
+}
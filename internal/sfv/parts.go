@@ -0,0 +1,151 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// CreateJoinedWithOptions hashes parts as one file, the way split archives
+// like name.001, name.002 and so on are verified, and returns a single entry
+// named name. If a part can't be hashed the parts which failed are returned
+// instead.
+func CreateJoinedWithOptions(t ChecksumType, name string, parts []string, opts Options) []ChecksumFile {
+	joined := ChecksumFile{t, StatusOK, name, 0, "", "", 0, false, nil, nil, "", "", nil}
+
+	failed := make([]ChecksumFile, 0)
+	for _, part := range statFiles(t, parts, &opts) {
+		if part.Status != StatusOK {
+			failed = append(failed, part)
+		} else if part.Filesize == sizeUnknown || joined.Filesize == sizeUnknown {
+			joined.Filesize = sizeUnknown
+		} else {
+			joined.Filesize += part.Filesize
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	reader := &partsReader{parts: parts, opts: &opts}
+	defer reader.Close()
+
+	progress := newProgress([]ChecksumFile{joined}, &opts)
+	progress.start()
+	calculateReaderChecksum(&joined, reader, progress, &opts)
+	progress.finish()
+
+	return []ChecksumFile{joined}
+}
+
+// partNames returns the parts of filename split into filename.001,
+// filename.002 and so on, or none if there's no first part
+func partNames(filename string, opts *Options) []string {
+	parts := make([]string, 0)
+	for i := 1; ; i++ {
+		part := fmt.Sprintf("%s.%03d", filename, i)
+		if _, err := os.Stat(opts.path(part)); err != nil {
+			return parts
+		}
+
+		parts = append(parts, part)
+	}
+}
+
+// statParts sets the status and size of an entry which doesn't exist as a
+// file from its parts, if it has any
+func statParts(checksumFile *ChecksumFile, opts *Options) bool {
+	parts := partNames(checksumFile.Filename, opts)
+	if len(parts) == 0 {
+		return false
+	}
+
+	checksumFile.Status   = StatusOK
+	checksumFile.Filesize = 0
+	for _, part := range parts {
+		fileInfo, err := os.Stat(opts.path(part))
+		if err != nil {
+			checksumFile.Status = StatusStatFailed
+			return true
+		}
+
+		checksumFile.Filesize += fileInfo.Size()
+	}
+
+	return true
+}
+
+// partsReader reads parts one after another, only keeping one open at a time
+type partsReader struct {
+	parts []string
+	opts  *Options
+	file  *os.File
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.file == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+
+			file, err := os.Open(r.opts.path(r.parts[0]))
+			if err != nil {
+				return 0, err
+			}
+			r.file  = file
+			r.parts = r.parts[1:]
+		}
+
+		n, err := r.file.Read(p)
+		if err == io.EOF {
+			r.file.Close()
+			r.file = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
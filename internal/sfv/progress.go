@@ -0,0 +1,334 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// progressInterval limits how often progress events are written
+const progressInterval = 100 * time.Millisecond
+
+// statusFileInterval is how often the status file is rewritten
+const statusFileInterval = 2 * time.Second
+
+// countingTemplate is used when the total is unknown, a bar or percentage
+// would be misleading
+const countingTemplate pb.ProgressBarTemplate = `{{counters . }} {{speed . }}`
+
+type progressEvent struct {
+	Done  int64  `json:"done"`
+	Total int64  `json:"total"`
+	File  string `json:"file"`
+}
+
+type ProgressUnit int
+
+const (
+	ProgressBytes ProgressUnit = iota
+	ProgressFiles
+)
+
+// ProgressStyle is how progress is shown
+type ProgressStyle int
+
+const (
+	// ProgressStyleBar redraws a progress bar
+	ProgressStyleBar ProgressStyle = iota
+	// ProgressStyleLog prints a plain line every ProgressRefreshRate, for
+	// logs which can't show a redrawn bar
+	ProgressStyleLog
+)
+
+// progress advances the progress bar and writes progress events for one run,
+// possibly for several files hashed at once
+type progress struct {
+	bar      *pb.ProgressBar
+	unit     ProgressUnit
+	writer   io.Writer
+	callback func(filename string, done, total int64)
+
+	mu   sync.Mutex
+	last time.Time
+
+	// Log lines count files and bytes both, whatever the unit
+	style      ProgressStyle
+	interval   time.Duration
+	files      int64
+	bytes      int64
+	totalFiles int64
+	totalBytes int64
+	started    time.Time
+	stop       chan struct{}
+	stopped    sync.WaitGroup
+
+	// The status file is rewritten with the file being read, guarded by mu
+	statusFile   string
+	current      string
+	statusFailed bool
+}
+
+// newProgress creates a progress for hashing checksumFiles. Counting bytes,
+// a bar is only shown if the size of every file is known.
+func newProgress(checksumFiles []ChecksumFile, opts *Options) *progress {
+	files := 0
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status == StatusOK {
+			files++
+		}
+	}
+
+	var bar *pb.ProgressBar
+	if opts.ProgressUnit == ProgressFiles {
+		bar = pb.New(files)
+	} else if total := totalSize(checksumFiles); total == sizeUnknown {
+		bar = pb.New64(0)
+		bar.SetTemplate(countingTemplate)
+		bar.Set(pb.Bytes, true)
+	} else {
+		bar = pb.New64(total)
+		bar.Set(pb.Bytes, true)
+	}
+	bar.SetRefreshRate(opts.ProgressRefreshRate)
+	if opts.ProgressWidth > 0 {
+		bar.SetWidth(opts.ProgressWidth)
+	}
+
+	return &progress{bar: bar, unit: opts.ProgressUnit, writer: opts.ProgressWriter, callback: opts.ProgressFunc,
+		style: opts.ProgressStyle, interval: opts.ProgressRefreshRate,
+		totalFiles: int64(files), totalBytes: totalSize(checksumFiles), statusFile: opts.StatusFile}
+}
+
+// start shows the progress until finish is called
+func (p *progress) start() {
+	p.started = time.Now()
+	p.stop = make(chan struct{})
+
+	if p.statusFile != "" {
+		p.every(statusFileInterval, p.writeStatus)
+	}
+
+	if p.style != ProgressStyleLog {
+		p.bar.Start()
+		return
+	}
+
+	p.every(p.interval, p.logLine)
+}
+
+// every calls fn each interval until finish is called
+func (p *progress) every(interval time.Duration, fn func()) {
+	p.stopped.Add(1)
+	go func() {
+		defer p.stopped.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *progress) finish() {
+	close(p.stop)
+	p.stopped.Wait()
+
+	if p.statusFile != "" {
+		p.writeStatus()
+	}
+
+	if p.style != ProgressStyleLog {
+		p.bar.Finish()
+		return
+	}
+
+	p.logLine()
+}
+
+// logLine prints the files and bytes processed so far and the throughput
+func (p *progress) logLine() {
+	bytes := atomic.LoadInt64(&p.bytes)
+
+	throughput := 0.0
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		throughput = float64(bytes) / 1e6 / elapsed
+	}
+
+	fmt.Fprintf(os.Stderr, "Processed %d/%d files, %.1f MB, %.1f MB/s\n",
+		atomic.LoadInt64(&p.files), p.totalFiles, float64(bytes)/1e6, throughput)
+}
+
+// writeStatus replaces the status file with the progress so far, so another
+// process reading it never sees a partly written file
+func (p *progress) writeStatus() {
+	p.mu.Lock()
+	current := p.current
+	p.mu.Unlock()
+
+	bytes := atomic.LoadInt64(&p.bytes)
+
+	throughput := 0.0
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		throughput = float64(bytes) / 1e6 / elapsed
+	}
+
+	total := "unknown"
+	if p.totalBytes != sizeUnknown {
+		total = fmt.Sprintf("%.1f MB", float64(p.totalBytes)/1e6)
+	}
+
+	status := fmt.Sprintf("Files:   %d/%d\nBytes:   %.1f MB of %s\nRate:    %.1f MB/s\nCurrent: %s\nUpdated: %s\n",
+		atomic.LoadInt64(&p.files), p.totalFiles, float64(bytes)/1e6, total, throughput, current,
+		time.Now().Format(time.RFC3339))
+
+	file, err := ioutil.TempFile(filepath.Dir(p.statusFile), "."+filepath.Base(p.statusFile)+".")
+	if err == nil {
+		_, err = file.WriteString(status)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err == nil {
+			err = os.Rename(file.Name(), p.statusFile)
+		}
+
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}
+
+	// The run goes on without it, so only tell once
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil && !p.statusFailed {
+		p.statusFailed = true
+		warn("%s: can't write status: %v", p.statusFile, err)
+	}
+}
+
+func (p *progress) add(n int, file string) {
+	atomic.AddInt64(&p.bytes, int64(n))
+
+	if p.statusFile != "" {
+		p.mu.Lock()
+		p.current = file
+		p.mu.Unlock()
+	}
+
+	if p.unit == ProgressFiles {
+		return
+	}
+
+	p.bar.Add(n)
+	p.write(file, false)
+}
+
+// skip counts n bytes of file as read without reading them, like a file
+// already hashed as another entry or the part hashed before resuming
+func (p *progress) skip(n int64, file string) {
+	atomic.AddInt64(&p.bytes, n)
+
+	if p.unit == ProgressFiles {
+		return
+	}
+
+	p.bar.Add64(n)
+	p.write(file, false)
+}
+
+// done is called when a file has been hashed
+func (p *progress) done(file string) {
+	atomic.AddInt64(&p.files, 1)
+
+	if p.unit == ProgressFiles {
+		p.bar.Increment()
+	}
+
+	p.write(file, true)
+}
+
+func (p *progress) write(file string, force bool) {
+	if p.writer == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !force && time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+
+	event, err := json.Marshal(progressEvent{p.bar.Current(), p.bar.Total(), file})
+	if err != nil {
+		return
+	}
+
+	p.writer.Write(append(event, '\n'))
+}
+
+// progressReader counts the bytes read and advances the progress
+type progressReader struct {
+	reader   io.Reader
+	progress *progress
+	filename string
+	size     int64
+	count    int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+
+	r.count += int64(n)
+	r.progress.add(n, r.filename)
+	if r.progress.callback != nil {
+		r.progress.callback(r.filename, r.count, r.size)
+	}
+
+	return n, err
+}
@@ -0,0 +1,157 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+)
+
+// quickChunkSize is how much of the start and of the end of a file the quick
+// fingerprint reads, 4 MiB
+const quickChunkSize = 4 * 1024 * 1024
+
+// quick is a fingerprint of the size of the data and its first and last
+// quickChunkSize bytes, truncated SHA256 of them. It's for telling quickly
+// that a huge file probably didn't change, not a hash of the content: changes
+// in the middle of a file go unnoticed. Written this way it reads the whole
+// data, calculateQuickChecksum seeks past the middle of files instead.
+type quick struct {
+	size int64
+	head []byte
+	tail []byte
+}
+
+func newQuick() hash.Hash {
+	return &quick{}
+}
+
+func (q *quick) Reset() {
+	q.size = 0
+	q.head = q.head[:0]
+	q.tail = q.tail[:0]
+}
+
+func (q *quick) Size() int {
+	return 16
+}
+
+func (q *quick) BlockSize() int {
+	return sha256.BlockSize
+}
+
+func (q *quick) Write(p []byte) (int, error) {
+	n := len(p)
+	q.size += int64(n)
+
+	if len(q.head) < quickChunkSize {
+		part := p
+		if len(part) > quickChunkSize-len(q.head) {
+			part = part[:quickChunkSize-len(q.head)]
+		}
+
+		q.head = append(q.head, part...)
+		p = p[len(part):]
+	}
+
+	// Only the last chunk after the head is kept
+	q.tail = append(q.tail, p...)
+	if len(q.tail) > quickChunkSize {
+		q.tail = q.tail[:copy(q.tail, q.tail[len(q.tail)-quickChunkSize:])]
+	}
+
+	return n, nil
+}
+
+// skip counts n bytes in the middle of the data without reading them, which
+// is only right once the head is full and before the tail is written
+func (q *quick) skip(n int64) {
+	q.size += n
+}
+
+func (q *quick) Sum(in []byte) []byte {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, q.size)
+	h.Write(q.head)
+	h.Write(q.tail)
+
+	return append(in, h.Sum(nil)[:q.Size()]...)
+}
+
+// calculateQuickChecksum fingerprints a file of TypeQuick by reading its
+// first and last chunk only, counting the skipped middle as read. Files too
+// small to skip anything are read whole.
+func calculateQuickChecksum(checksumFile *ChecksumFile, file *os.File, progress *progress) {
+	defer progress.done(checksumFile.Filename)
+
+	size := checksumFile.Filesize
+	q := &quick{}
+
+	_, err := io.CopyN(q, file, quickChunkSize)
+	if err == nil {
+		_, err = file.Seek(size-quickChunkSize, io.SeekStart)
+	}
+	if err == nil {
+		q.skip(size - 2*quickChunkSize)
+		_, err = io.CopyN(q, file, quickChunkSize)
+	}
+	if err != nil {
+		checksumFile.Status = StatusFailedCheckSum
+		return
+	}
+
+	progress.skip(size, checksumFile.Filename)
+
+	// Another process might have truncated or appended to the file since it
+	// was stat'ed, which would otherwise give a fingerprint of the wrong data
+	if fileInfo, err := file.Stat(); err != nil || fileInfo.Size() != size {
+		checksumFile.Status = StatusSizeChanged
+		return
+	}
+
+	checksumFile.Status   = StatusCheckSumOK
+	checksumFile.Checksum = formatChecksum(TypeQuick, q)
+}
+
+// hasQuick tells whether any entry to be written is a quick fingerprint,
+// which the verification file is marked for so they're not taken for hashes
+func hasQuick(checksumFiles []ChecksumFile) bool {
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status == StatusCheckSumOK && checksumFile.ChecksumType == TypeQuick {
+			return true
+		}
+	}
+
+	return false
+}
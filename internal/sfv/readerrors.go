@@ -0,0 +1,92 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"io"
+)
+
+// readErrorBlockSize is how much is zero-filled for each read error, the
+// sector size of most disks
+const readErrorBlockSize = 4096
+
+// ByteRange is a range of bytes of a file, End not included
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// tolerantReader reads a file of a known size, zero-filling the blocks
+// which can't be read instead of failing, and notes where they are
+type tolerantReader struct {
+	file   io.ReaderAt
+	offset int64
+	size   int64
+	bad    []ByteRange
+}
+
+func (r *tolerantReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - r.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := r.file.ReadAt(p, r.offset)
+	if n > 0 || err == nil || err == io.EOF {
+		// What was read before an error is kept, the error comes again on
+		// the next read
+		r.offset += int64(n)
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+
+	// Disks fail whole sectors, so fill up to the end of this one
+	if block := readErrorBlockSize - int(r.offset%readErrorBlockSize); len(p) > block {
+		p = p[:block]
+	}
+	for i, _ := range p {
+		p[i] = 0
+	}
+
+	// Adjacent blocks are one damaged region
+	if last := len(r.bad) - 1; last >= 0 && r.bad[last].End == r.offset {
+		r.bad[last].End += int64(len(p))
+	} else {
+		r.bad = append(r.bad, ByteRange{r.offset, r.offset + int64(len(p))})
+	}
+	r.offset += int64(len(p))
+
+	return len(p), nil
+}
@@ -0,0 +1,231 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// resumeInterval is how often the state of a hash is saved
+const resumeInterval = 10 * time.Second
+
+// resumeMutex serializes updating the saved states, files hashed at once
+// each update their own
+var resumeMutex sync.Mutex
+
+// resumeState is the saved state of hashing a file part way, which is only
+// resumed for the same file unchanged since
+type resumeState struct {
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Type     string    `json:"type"`
+	Offset   int64     `json:"offset"`
+	State    []byte    `json:"state"`
+}
+
+// calculateResumableChecksum hashes a file like calculateReaderChecksum,
+// saving the state of the hash to Options.ResumeState as it goes and
+// resuming from the state saved by an earlier run. It returns false without
+// reading anything if the hash of the file's type can't save its state.
+func calculateResumableChecksum(checksumFile *ChecksumFile, file *os.File, progress *progress, opts *Options) bool {
+	hasher := newHash(checksumFile.ChecksumType, opts)
+	marshaler, ok := hasher.(encoding.BinaryMarshaler)
+	if !ok {
+		return false
+	}
+	unmarshaler, ok := hasher.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return false
+	}
+	defer progress.done(checksumFile.Filename)
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		checksumFile.Status = StatusFailedCheckSum
+		return true
+	}
+
+	state := resumeState{File: opts.path(checksumFile.Filename), Size: fileInfo.Size(), Modified: fileInfo.ModTime(),
+		Type: TypeToString(checksumFile.ChecksumType)}
+
+	if saved, ok := loadResumeStates(opts.ResumeState)[state.File]; ok && saved.of(state) {
+		if unmarshaler.UnmarshalBinary(saved.State) == nil {
+			if _, err := file.Seek(saved.Offset, io.SeekStart); err == nil {
+				state.Offset = saved.Offset
+			} else {
+				hasher.Reset()
+			}
+		}
+	}
+
+	counter := &progressReader{reader: bufio.NewReader(file), progress: progress, filename: checksumFile.Filename,
+		size: checksumFile.Filesize, count: state.Offset}
+	progress.skip(state.Offset, checksumFile.Filename)
+
+	save := func() {
+		state.Offset = counter.count
+		if state.State, err = marshaler.MarshalBinary(); err == nil {
+			err = updateResumeState(opts.ResumeState, state.File, &state)
+		}
+		if err != nil {
+			warn("%s: can't save the hash state: %v", opts.ResumeState, err)
+		}
+	}
+
+	bufPtr := getBuffer(opts.BufferSize)
+	defer bufferPool.Put(bufPtr)
+	buf := *bufPtr
+
+	lastSave := time.Now()
+	for {
+		if stopped(opts.Stop) {
+			save()
+			checksumFile.Status = StatusInterrupted
+			return true
+		}
+
+		count, readErr := counter.Read(buf)
+		hasher.Write(buf[:count])
+
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			save()
+			checksumFile.Status = StatusFailedCheckSum
+			return true
+		}
+
+		if time.Since(lastSave) >= resumeInterval {
+			save()
+			lastSave = time.Now()
+		}
+	}
+
+	if counter.count != checksumFile.Filesize {
+		checksumFile.Status = StatusSizeChanged
+		return true
+	}
+
+	// Only the state of this file is done with, other interrupted files
+	// might still be resumed
+	if err := updateResumeState(opts.ResumeState, state.File, nil); err != nil {
+		warn("%s: can't remove the hash state: %v", opts.ResumeState, err)
+	}
+
+	checksumFile.Status   = StatusCheckSumOK
+	checksumFile.Checksum = formatChecksum(checksumFile.ChecksumType, hasher)
+	return true
+}
+
+// of tells whether s is a state of the same file as other, unchanged since
+func (s resumeState) of(other resumeState) bool {
+	return s.File == other.File && s.Size == other.Size && s.Modified.Equal(other.Modified) && s.Type == other.Type
+}
+
+// loadResumeStates reads the saved states by the files they're of, none if
+// there are no saved states
+func loadResumeStates(filename string) map[string]resumeState {
+	states := make(map[string]resumeState)
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return states
+	}
+
+	if err := json.Unmarshal(data, &states); err != nil {
+		warn("%s: not a saved hash state, ignored: %v", filename, err)
+		return make(map[string]resumeState)
+	}
+
+	return states
+}
+
+// updateResumeState replaces the saved state of file, or removes it if
+// state is nil, keeping the states of the other files. The file is removed
+// once there are no states left.
+func updateResumeState(filename string, file string, state *resumeState) error {
+	resumeMutex.Lock()
+	defer resumeMutex.Unlock()
+
+	states := loadResumeStates(filename)
+	if state == nil {
+		if _, ok := states[file]; !ok {
+			return nil
+		}
+		delete(states, file)
+	} else {
+		states[file] = *state
+	}
+
+	if len(states) == 0 {
+		return os.Remove(filename)
+	}
+
+	return saveResumeStates(filename, states)
+}
+
+// saveResumeStates replaces the saved states through a rename, so an
+// interruption while saving leaves the previous states
+func saveResumeStates(filename string, states map[string]resumeState) error {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
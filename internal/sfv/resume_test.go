@@ -0,0 +1,85 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResumeStateOfOtherFileKept(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	other := resumeState{File: "huge.iso", Size: 1 << 40, Modified: time.Now(), Type: "sha256", Offset: 1 << 30}
+	if err := updateResumeState(stateFile, other.File, &other); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.BaseDir = "testdata"
+	opts.ResumeState = stateFile
+
+	checksumFiles := CreateWithOptions(TypeSHA256, []string{"hello.txt"}, opts)
+	if checksumFiles[0].Status != StatusCheckSumOK {
+		t.Fatalf("status is %s", StatusTypeToString(checksumFiles[0].Status))
+	}
+
+	if _, ok := loadResumeStates(stateFile)["huge.iso"]; !ok {
+		t.Errorf("state of another file removed")
+	}
+}
+
+func TestResumeStatesByFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	first := resumeState{File: "first.iso", Size: 1 << 40, Modified: time.Now(), Type: "sha256", Offset: 1 << 20}
+	second := resumeState{File: "second.iso", Size: 1 << 40, Modified: time.Now(), Type: "sha256", Offset: 1 << 30}
+	for _, state := range []resumeState{first, second} {
+		if err := updateResumeState(stateFile, state.File, &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states := loadResumeStates(stateFile)
+	if states["first.iso"].Offset != first.Offset || states["second.iso"].Offset != second.Offset {
+		t.Errorf("saving a state replaced another: %+v", states)
+	}
+
+	for _, state := range []resumeState{first, second} {
+		if err := updateResumeState(stateFile, state.File, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Errorf("state file left without states: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"strings"
+)
+
+// selfTestKey is the HMAC key the HMAC test vectors were calculated with
+const selfTestKey = "key"
+
+type selfTestVector struct {
+	checksumType ChecksumType
+	input        string
+	checksum     string
+}
+
+// selfTestVectors are known checksums of the empty string and "abc"
+var selfTestVectors = []selfTestVector{
+	{TypeCRC32, "", "00000000"},
+	{TypeCRC32, "abc", "352441c2"},
+	{TypeMD5, "", "d41d8cd98f00b204e9800998ecf8427e"},
+	{TypeMD5, "abc", "900150983cd24fb0d6963f7d28e17f72"},
+	{TypeSHA1, "", "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+	{TypeSHA1, "abc", "a9993e364706816aba3e25717850c26c9cd0d89d"},
+	{TypeSHA256, "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	{TypeSHA256, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	{TypeSHA512_256, "", "c672b8d1ef56ed28ab87c3622c5114069bdd3ad7b8f9737498d0c01ecef0967a"},
+	{TypeSHA512_256, "abc", "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23"},
+	{TypeHMACSHA256, "", "5d5d139563c95b5967b9bd9a8c9b233a9dedb45072794cd232dc1b74832607d0"},
+	{TypeHMACSHA256, "abc", "9c196e32dc0175f86f4b1cb89289d6619de6bee699e4c378e68309ed97a1a6ab"},
+	{TypeSHA384, "", "38b060a751ac96384cd9327eb1b1e36a21fdb71114be07434c0cc7bf63f6e1da274edebfe76f65fbd51ad2f14898b95b"},
+	{TypeSHA384, "abc", "cb00753f45a35e8bb5a03d699ac65007272c32ab0eded1631a8b605a43ff5bed8086072ba1e7cc2358baeca134c825a7"},
+	{TypeSHA512, "", "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"},
+	{TypeSHA512, "abc", "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+	{TypeED2K, "", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+	{TypeED2K, "abc", "a448017aaf21d8525fc10ae87aa6729d"},
+	// Quick fingerprints are gosfv's own, these are SHA256 of the size as a
+	// little endian int64 followed by the data, truncated to 16 bytes
+	{TypeQuick, "", "af5570f5a1810b7af78caf4bc70a660f"},
+	{TypeQuick, "abc", "ce91dc5eec0139adf091900d225971d6"},
+}
+
+type SelfTestResult struct {
+	ChecksumType ChecksumType
+	Input        string
+	Checksum     string
+	ChecksumWant string
+}
+
+// OK tells whether the calculated checksum is the known one
+func (r SelfTestResult) OK() bool {
+	return r.Checksum == r.ChecksumWant
+}
+
+// SelfTest hashes known test vectors with every checksum type, to confirm
+// that the build calculates checksums correctly
+func SelfTest() []SelfTestResult {
+	opts := DefaultOptions()
+	opts.HMACKey = []byte(selfTestKey)
+
+	results := make([]SelfTestResult, len(selfTestVectors))
+	for i, vector := range selfTestVectors {
+		// Reading from memory never fails
+		checksum, _ := hashReader(vector.checksumType, strings.NewReader(vector.input), &opts)
+
+		results[i] = SelfTestResult{vector.checksumType, vector.input, checksum, vector.checksum}
+	}
+
+	return results
+}
@@ -32,21 +32,34 @@ package sfv
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 
 	"hash"
 	"hash/crc32"
-
-	"github.com/cheggaaa/pb/v3"
 )
 
 var (
@@ -54,6 +67,13 @@ var (
 	Version string
 )
 
+// stdinFilename is the filename which makes Create read standard input
+const stdinFilename = "-"
+
+// sizeUnknown is the Filesize of files which can't be stat'ed for their size
+// before they're read
+const sizeUnknown = -1
+
 type ChecksumType int
 type ChecksumStatus int
 
@@ -64,12 +84,25 @@ type ChecksumFile struct {
 	Filesize     int64
 	Checksum     string
 	ChecksumWant string
+	FilesizeWant int64
+	Symlink      bool
+	Metadata     *Metadata
+	MetadataWant *Metadata
+	Xattrs       string
+	XattrsWant   string
+
+	// BadRegions are the parts of a file with StatusPartialRead which
+	// couldn't be read and were hashed as zeros
+	BadRegions []ByteRange
 }
 
-type hasherInfo struct {
-	buf 		[]byte
-	hash    hash.Hash
-	hash32 	hash.Hash32
+// lineData is what a LineTemplate is executed with
+type lineData struct {
+	Filename  string
+	Checksum  string
+	Size      int64
+	Type      string
+	Integrity string
 }
 
 const (
@@ -81,6 +114,18 @@ const (
 	StatusNotFound
 	StatusNotFile
 	StatusStatFailed
+	StatusSizeNoMatch
+	StatusMissingKey
+	StatusSizeChanged
+	StatusSymlinkSkipped
+	StatusNotModified
+	StatusInterrupted
+	StatusNoSidecar
+	StatusUnstableRead
+	StatusMetadataChanged
+	StatusTypeSkipped
+	StatusPartialRead
+	StatusNoHashInName
 )
 
 const (
@@ -89,21 +134,168 @@ const (
 	TypeMD5
 	TypeSHA1
 	TypeSHA256
+	TypeSHA512_256
+	TypeHMACSHA256
+	TypeSHA512
+	TypeED2K
+	TypeSHA384
+	TypeQuick
 )
 
+// algorithm describes a checksum type. HMAC types have no factory since
+// they need a key, see newHash.
+type algorithm struct {
+	name     string
+	factory  func() hash.Hash
+	hexWidth int
+}
+
+// algorithms are the known checksum types, including registered ones
+var algorithms = map[ChecksumType]algorithm{
+	TypeCRC32:      {"crc32", func() hash.Hash { return crc32.NewIEEE() }, 8},
+	TypeMD5:        {"md5", md5.New, 32},
+	TypeSHA1:       {"sha1", sha1.New, 40},
+	TypeSHA256:     {"sha256", sha256.New, 64},
+	TypeSHA512_256: {"sha512-256", sha512.New512_256, 64},
+	TypeHMACSHA256: {"hmac-sha256", nil, 64},
+	TypeSHA384:     {"sha384", sha512.New384, 96},
+	TypeSHA512:     {"sha512", sha512.New, 128},
+	TypeED2K:       {"ed2k", newED2K, 32},
+	TypeQuick:      {"quick", newQuick, 32},
+}
+
+// checksumTypes lists all known checksum types, the most common type of
+// each width first
+var checksumTypes = []ChecksumType{
+	TypeCRC32,
+	TypeMD5,
+	TypeSHA1,
+	TypeSHA256,
+	TypeSHA512_256,
+	TypeHMACSHA256,
+	TypeSHA384,
+	TypeSHA512,
+	TypeED2K,
+	TypeQuick,
+}
+
+// RegisterHash adds a checksum type named name, hashed by hashes from
+// factory and written as hexWidth hex characters, and returns it. StringToType
+// finds it by name afterwards. It isn't safe to call while files are hashed,
+// so register hashes before creating or verifying anything.
+func RegisterHash(name string, factory func() hash.Hash, hexWidth int) ChecksumType {
+	name = strings.ToLower(name)
+	if StringToType(name) != TypeUnknown {
+		panic("sfv: RegisterHash called twice for " + name)
+	}
+
+	t := ChecksumType(len(checksumTypes) + 1)
+	algorithms[t] = algorithm{name, factory, hexWidth}
+	checksumTypes = append(checksumTypes, t)
+
+	return t
+}
+
 func StringToType(t string) ChecksumType {
+	for _, checksumType := range checksumTypes {
+		if algorithms[checksumType].name == t {
+			return checksumType
+		}
+	}
+
+	return TypeUnknown
+}
+
+// lineParser describes one checksum line format. The checksum type is either
+// fixed or, if typeGroup is set, read from the line. Hashes which are
+// Subresource Integrity strings tell the type themselves and are base64
+// whatever the Encoding.
+type lineParser struct {
+	checksumType ChecksumType
+	re           *regexp.Regexp
+	typeGroup    int
+	nameGroup    int
+	hashGroup    int
+	integrity    bool
+}
+
+// lineParsers are tried in order when parsing a checksum line, before the
+// "hash name" format of the coreutils family, see parseCoreutilsLine
+var lineParsers = []lineParser{
+	// sha384-<base64> name, as written with the sri format
+	{TypeUnknown, reIntegrityLine, 0, 2, 1, true},
+	// name hash
+	{TypeCRC32, regexp.MustCompile(`^([\w\pL\pM\pN\./-]+)[\s]+([0-9A-Fa-f]{8})$`), 0, 1, 2, false},
+	// ALGORITHM (name) = hash, as written by BSD tools and GNU's --tag
+	{TypeUnknown, regexp.MustCompile(`^([\w-]+) \(([\w\pL\pM\pN\./-]+)\) = ([\w]+)$`), 1, 2, 3, false},
+}
+
+// lineFormats are the built-in formats accepted by ParseLineFormat
+var lineFormats = map[string]string{
+	"sfv":       "{{.Filename}} {{.Checksum}}",
+	"md5sum":    "{{.Checksum}}  {{.Filename}}",
+	"sha256sum": "{{.Checksum}}  {{.Filename}}",
+	"sri":       "{{.Integrity}}  {{.Filename}}",
+	"checksum":  "{{.Checksum}}",
+}
+
+// ParseLineFormat parses either the name of a built-in format or a
+// text/template using .Filename, .Checksum, .Size, .Type and .Integrity.
+func ParseLineFormat(format string) (*template.Template, error) {
+	if lineFormat, ok := lineFormats[format]; ok {
+		format = lineFormat
+	}
+
+	return template.New("line").Parse(format + "\n")
+}
+
+func TypeToString(t ChecksumType) string {
+	if algorithm, ok := algorithms[t]; ok {
+		return algorithm.name
+	}
+
+	return "unknown"
+}
+
+// newHash returns a new hash for checksum type t
+func newHash(t ChecksumType, opts *Options) hash.Hash {
+	if t == TypeHMACSHA256 {
+		return hmac.New(sha256.New, opts.HMACKey)
+	} else if t == typeMulti {
+		return newMultiHash(opts.Types, opts)
+	}
+
+	return algorithms[t].factory()
+}
+
+// DefaultManifestName returns the conventional name of a file with
+// checksums of type t, like checksums.sfv for CRC32 or checksums.md5 for MD5
+func DefaultManifestName(t ChecksumType) string {
 	switch t {
-	case "crc32":
-		return TypeCRC32
-	case "md5":
-		return TypeMD5
-	case "sha1":
-		return TypeSHA1
-	case "sha256":
-		return TypeSHA256
+	case TypeCRC32:
+		return "checksums.sfv"
+	case TypeUnknown:
+		return "checksums.txt"
 	default:
-		return TypeUnknown
+		return "checksums." + TypeToString(t)
+	}
+}
+
+// typeHexWidth returns the length of a hex encoded checksum of type t
+func typeHexWidth(t ChecksumType) int {
+	return algorithms[t].hexWidth
+}
+
+// typeOfWidth returns the most common checksum type with checksums of width
+// hex characters, like SHA256 rather than SHA512/256 for 64
+func typeOfWidth(width int) ChecksumType {
+	for _, t := range checksumTypes {
+		if typeHexWidth(t) == width {
+			return t
+		}
 	}
+
+	return TypeUnknown
 }
 
 func StatusTypeToString(s ChecksumStatus) string {
@@ -122,272 +314,1492 @@ func StatusTypeToString(s ChecksumStatus) string {
 		return "File not a file"
 	case StatusStatFailed:
 		return "File stat failed"
+	case StatusSizeNoMatch:
+		return "File size doesn't match"
+	case StatusMissingKey:
+		return "HMAC key missing"
+	case StatusSizeChanged:
+		return "File size changed while reading"
+	case StatusSymlinkSkipped:
+		return "Symlink, skipped"
+	case StatusNotModified:
+		return "Not modified since"
+	case StatusInterrupted:
+		return "Interrupted"
+	case StatusNoSidecar:
+		return "Sidecar file not found"
+	case StatusUnstableRead:
+		return "File produced different bytes on re-read"
+	case StatusMetadataChanged:
+		return "File metadata changed"
+	case StatusTypeSkipped:
+		return "Type not selected, skipped"
+	case StatusPartialRead:
+		return "Read errors, damaged regions zero-filled"
+	case StatusNoHashInName:
+		return "No checksum in filename"
 	default:
 		return "Unknown"
 	}
 }
 
 func Create(t ChecksumType, files []string) []ChecksumFile {
-	var totalFileSize int64
-	checksumFiles := make([]ChecksumFile, len(files))
-	for i, file := range files {
-		checksumFiles[i] = createChecksumFile(t, file)
+	return CreateWithOptions(t, files, DefaultOptions())
+}
+
+func CreateWithOptions(t ChecksumType, files []string, opts Options) []ChecksumFile {
+	return create(t, files, &opts, nil)
+}
 
-		totalFileSize += checksumFiles[i].Filesize
+// create hashes files, calling hashed if set with each file when done
+func create(t ChecksumType, files []string, opts *Options, hashed func(ChecksumFile)) []ChecksumFile {
+	if opts.Recursive {
+		files = expandDirs(files, opts)
 	}
 
-	bar := pb.New64(totalFileSize)
-	bar.Set(pb.Bytes, true)
-	bar.Start()
+	checksumFiles := statFiles(t, files, opts)
 
-	for i, _ := range checksumFiles {
-		calculateChecksum(&checksumFiles[i], bar)
+	if !opts.Since.IsZero() {
+		checksumFiles = withoutStatus(checksumFiles, StatusNotModified)
 	}
 
-	bar.Finish()
+	progress := newProgress(checksumFiles, opts)
+	progress.start()
+
+	dups := duplicates(checksumFiles, opts)
+	forEachOrdered(len(checksumFiles), opts.Jobs, func(i int) {
+		if dups[i] < 0 {
+			calculateChecksum(&checksumFiles[i], progress, opts)
+		}
+	}, func(i int) {
+		// The entry duplicated is released first, so it's done
+		if dups[i] >= 0 {
+			copyHashed(&checksumFiles[i], checksumFiles[dups[i]], progress)
+		}
+
+		// Files are opened by the filename given, so it's changed only after
+		// hashing
+		if checksumFiles[i].Filename == stdinFilename {
+			checksumFiles[i].Filename = opts.StdinName
+		} else {
+			checksumFiles[i].Filename = opts.recordedPath(checksumFiles[i].Filename)
+		}
+
+		if hashed != nil {
+			hashed(checksumFiles[i])
+		}
+		opts.result(checksumFiles[i])
+	})
+
+	progress.finish()
 
 	return checksumFiles
 }
 
-func Verify(file string) []ChecksumFile {
-	totalFileSize, checksumFiles := parseSfvFile(file)
+// withoutStatus returns the entries which don't have status s
+func withoutStatus(checksumFiles []ChecksumFile, s ChecksumStatus) []ChecksumFile {
+	filtered := make([]ChecksumFile, 0, len(checksumFiles))
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status != s {
+			filtered = append(filtered, checksumFile)
+		}
+	}
 
-	bar := pb.New64(totalFileSize)
-	bar.Set(pb.Bytes, true)
-	bar.Start()
+	return filtered
+}
 
-	for i, _ := range checksumFiles {
-		calculateChecksum(&checksumFiles[i], bar)
+// statFiles creates a ChecksumFile for each file. Stat'ing is mostly waiting
+// on the file system, so it's done by several workers at once.
+func statFiles(t ChecksumType, files []string, opts *Options) []ChecksumFile {
+	checksumFiles := make([]ChecksumFile, len(files))
 
-		if checksumFiles[i].Status == StatusCheckSumOK &&
-		   checksumFiles[i].Checksum != checksumFiles[i].ChecksumWant {
-			checksumFiles[i].Status = StatusCheckSumNoMatch
-		}
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				checksumFiles[i] = createChecksumFile(t, files[i], opts)
+			}
+		}()
 	}
 
-	bar.Finish()
+	for i, _ := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
 	return checksumFiles
 }
 
-func parseSfvFile(filename string) (int64, []ChecksumFile) {
-	var totalFileSize int64
-	var file *os.File
-	var err error
+// ReadFileList reads newline separated filenames, skipping blank lines and
+// comments starting with #
+func ReadFileList(filename string) []string {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
 
-	if filename != "" {
-		file, err = os.Open(filename)
-		if err != nil {
-			log.Fatal(err)
+	files := make([]string, 0)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
 
-		defer file.Close()
+		files = append(files, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return files
+}
+
+func Verify(file string) []ChecksumFile {
+	return VerifyWithOptions(file, DefaultOptions())
+}
+
+func VerifyWithOptions(file string, opts Options) []ChecksumFile {
+	var checksumFiles []ChecksumFile
+	if opts.SignatureFile != "" {
+		// The content verified is the content parsed, whatever happens to
+		// the file in between
+		manifest := readManifest(file)
+		checkSignature(manifest, file, &opts)
+		checksumFiles = parseManifest(bytes.NewReader(manifest), file, &opts)
 	} else {
-		file = os.Stdin
+		checksumFiles = parseSfvFile(file, &opts)
+	}
+	if len(checksumFiles) == 0 {
+		return checksumFiles
 	}
 
-	checksumFiles := make([]ChecksumFile, 0)
+	if len(opts.OnlyTypes) > 0 {
+		skipTypes(checksumFiles, &opts)
+	}
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
+	checkPresent(checksumFiles, &opts)
 
-	reCrc32  := regexp.MustCompile(`^([\w\.]+) ([\w]{8})$`)
-	reMd5    := regexp.MustCompile(`^MD5 \(([\w\.]+)\) = ([\w]{32})$`)
-	reSha1   := regexp.MustCompile(`^([\w]{40})  ([\w\.]+)$`)
-	reSha256 := regexp.MustCompile(`^([\w]{64})  ([\w\.]+)$`)
+	if opts.ListedFunc != nil {
+		opts.ListedFunc(checksumFiles)
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line[0:1] == ";" {
-			continue
-		}
+	if opts.SampleSize > 0 || opts.SamplePercent > 0 {
+		checksumFiles = sample(checksumFiles, &opts)
+	}
+
+	hashEntries(checksumFiles, &opts)
+
+	return checksumFiles
+}
 
-		var checksumFile ChecksumFile
-		if reCrc32.MatchString(line) {
-			matches := reCrc32.FindStringSubmatch(line)
+// VerifyEntriesWithOptions verifies entries which aren't read from a
+// verification file, like checksums given on the command line
+func VerifyEntriesWithOptions(checksumFiles []ChecksumFile, opts Options) []ChecksumFile {
+	for i, _ := range checksumFiles {
+		verifyChecksumFile(&checksumFiles[i], &opts)
+	}
 
-			checksumFile.ChecksumType = TypeCRC32
-			checksumFile.Filename     = matches[1]
-			checksumFile.ChecksumWant = matches[2]
-		} else if reMd5.MatchString(line) {
-			matches := reMd5.FindStringSubmatch(line)
+	hashEntries(checksumFiles, &opts)
 
-			checksumFile.ChecksumType = TypeMD5
-			checksumFile.Filename     = matches[1]
-			checksumFile.ChecksumWant = matches[2]
-		} else if reSha1.MatchString(line) {
-			matches := reSha1.FindStringSubmatch(line)
+	return checksumFiles
+}
 
-			checksumFile.ChecksumType = TypeSHA1
-			checksumFile.Filename     = matches[2]
-			checksumFile.ChecksumWant = matches[1]
-		} else if reSha256.MatchString(line) {
-			matches := reSha256.FindStringSubmatch(line)
+// hashEntries hashes the stat'ed entries and compares their checksums
+func hashEntries(checksumFiles []ChecksumFile, opts *Options) {
+	progress := newProgress(checksumFiles, opts)
+	progress.start()
 
-			checksumFile.ChecksumType = TypeSHA256
-			checksumFile.Filename     = matches[2]
-			checksumFile.ChecksumWant = matches[1]
-		} else {
-			// Unknown checksum type
-			continue
+	// Duplicates are compared against their own checksum once the entry
+	// they duplicate is hashed, which is as hashed before being compared
+	dups   := duplicates(checksumFiles, opts)
+	hashed := make([]ChecksumFile, len(checksumFiles))
+	check  := func(i int) {
+		compareChecksum(&checksumFiles[i])
+
+		if opts.RehashOnMismatch {
+			rehashMismatch(&checksumFiles[i], opts)
 		}
 
-		verifyChecksumFile(&checksumFile)
-		totalFileSize += checksumFile.Filesize
+		checkMetadata(&checksumFiles[i])
+	}
 
-		checksumFiles = append(checksumFiles, checksumFile)
+	forEachOrdered(len(checksumFiles), opts.Jobs, func(i int) {
+		if dups[i] >= 0 {
+			return
+		}
+
+		calculateChecksum(&checksumFiles[i], progress, opts)
+		hashed[i] = checksumFiles[i]
+		check(i)
+	}, func(i int) {
+		if dups[i] >= 0 {
+			copyHashed(&checksumFiles[i], hashed[dups[i]], progress)
+			check(i)
+		}
+		opts.result(checksumFiles[i])
+	})
+
+	progress.finish()
+}
+
+// rehashMismatch reads a file which didn't match its checksum once more, so
+// a transient read error isn't reported as corruption. Reads giving
+// different checksums get StatusUnstableRead, which points to failing
+// hardware. The second read isn't counted by the progress bar, which already
+// counted the file.
+func rehashMismatch(checksumFile *ChecksumFile, opts *Options) {
+	if checksumFile.Status != StatusCheckSumNoMatch {
+		return
+	}
+
+	first := checksumFile.Checksum
+	checksumFile.Status = StatusOK
+	checksumFile.Checksum = ""
+
+	calculateChecksum(checksumFile, newProgress([]ChecksumFile{*checksumFile}, opts), opts)
+	if checksumFile.Status == StatusCheckSumOK && checksumFile.Checksum != first {
+		checksumFile.Status = StatusUnstableRead
+		return
 	}
 
-	return totalFileSize, checksumFiles
+	compareChecksum(checksumFile)
 }
 
-func WriteToFile(checksumFiles []ChecksumFile, filename string) {
-	var file *os.File
-	var err error
+// ParsePair parses a checksum and filename given as checksum:filename. The
+// type is taken from the length of the checksum unless t is given, or from
+// the prefix of Subresource Integrity strings like sha384-<base64>.
+func ParsePair(pair string, t ChecksumType) (ChecksumFile, error) {
+	var checksumFile ChecksumFile
 
-	if filename != "" {
-		file, err = os.Create(filename)
-		if err != nil {
-			log.Fatal(err)
-		}
+	// Checksums never contain a colon, but filenames might
+	i := strings.Index(pair, ":")
+	if i < 1 || i == len(pair)-1 {
+		return checksumFile, fmt.Errorf("Expected checksum:file, got %s", pair)
+	}
 
-		defer file.Close()
-	} else {
-		file = os.Stdout
+	checksumFile.ChecksumWant = pair[:i]
+	checksumFile.Filename     = pair[i+1:]
+	checksumFile.FilesizeWant = sizeUnknown
+
+	checksumFile.ChecksumType = t
+	if integrityType, checksum, ok := parseIntegrity(checksumFile.ChecksumWant); ok {
+		checksumFile.ChecksumType = integrityType
+		checksumFile.ChecksumWant = checksum
+	} else if t == TypeUnknown {
+		checksumFile.ChecksumType = typeOfWidth(len(checksumFile.ChecksumWant))
+	}
+
+	if checksumFile.ChecksumType == TypeUnknown ||
+	   len(checksumFile.ChecksumWant) != typeHexWidth(checksumFile.ChecksumType) {
+		return checksumFile, fmt.Errorf("Unknown checksum type of %s", pair)
+	}
+
+	return checksumFile, nil
+}
+
+// checkPresent stops before anything is hashed if fewer than RequirePresent
+// percent of the files exist, which usually means verification was started
+// in the wrong directory
+func checkPresent(checksumFiles []ChecksumFile, opts *Options) {
+	if opts.RequirePresent <= 0 || len(checksumFiles) == 0 {
+		return
 	}
 
-	date := time.Now().UTC().Format(time.RFC3339)
-	file.WriteString(fmt.Sprintf("; Generated by gosfv version %s(%s) at %s\n", Version, Commit, date))
+	present := 0
+	selected := 0
 	for _, checksumFile := range checksumFiles {
-		if checksumFile.Status == StatusCheckSumOK {
-			switch checksumFile.ChecksumType {
-			case TypeCRC32:
-				_, err = file.WriteString(fmt.Sprintf("%s %s\n", checksumFile.Filename, checksumFile.Checksum))
-			case TypeMD5:
-				_, err = file.WriteString(fmt.Sprintf("MD5 (%s) = %s\n", checksumFile.Filename, checksumFile.Checksum))
-			case TypeSHA1:
-				_, err = file.WriteString(fmt.Sprintf("%s  %s\n", checksumFile.Checksum, checksumFile.Filename))
-			case TypeSHA256:
-				_, err = file.WriteString(fmt.Sprintf("%s  %s\n", checksumFile.Checksum, checksumFile.Filename))
-			}
+		if checksumFile.Status == StatusTypeSkipped {
+			continue
+		}
+
+		selected++
+		if checksumFile.Status != StatusNotFound {
+			present++
+		}
+	}
+
+	if selected == 0 {
+		return
+	}
 
-			if err != nil {
-				log.Fatal(err)
+	percent := float64(present) * 100 / float64(selected)
+	if percent < opts.RequirePresent {
+		log.Fatalf("Only %d of %d files (%.1f%%) found, %.1f%% required. Is this the right directory?",
+			present, selected, percent, opts.RequirePresent)
+	}
+}
+
+// skipTypes marks the entries which aren't of any of Options.OnlyTypes as
+// StatusTypeSkipped, so they aren't hashed
+func skipTypes(checksumFiles []ChecksumFile, opts *Options) {
+	for i, _ := range checksumFiles {
+		selected := false
+		for _, t := range opts.OnlyTypes {
+			if checksumFiles[i].ChecksumType == t {
+				selected = true
+				break
 			}
 		}
+
+		if !selected {
+			checksumFiles[i].Status = StatusTypeSkipped
+		}
 	}
 }
 
-func calculateChecksum(checksumFile *ChecksumFile, pb *pb.ProgressBar) {
-	if checksumFile.Status != StatusOK {
-		return
+// sample picks SampleSize or SamplePercent of the entries at random, keeping
+// their order. The same SampleSeed picks the same entries.
+func sample(checksumFiles []ChecksumFile, opts *Options) []ChecksumFile {
+	n := opts.SampleSize
+	if opts.SamplePercent > 0 {
+		n = int(math.Ceil(float64(len(checksumFiles)) * opts.SamplePercent / 100))
 	}
 
-	// Initial stat of file have already been handled, so no need to verify errors
-	// of opening the file
-	file, _ := os.Open(checksumFile.Filename)
-	defer file.Close()
+	if n >= len(checksumFiles) {
+		return checksumFiles
+	}
 
-	var hasher hasherInfo
-	switch checksumFile.ChecksumType {
-	case TypeCRC32:
-		hasher.hash32 = crc32.NewIEEE()
-		hasher.buf = make([]byte, hasher.hash32.BlockSize())
-	case TypeMD5:
-		hasher.hash = md5.New()
-		hasher.buf = make([]byte, md5.BlockSize)
-	case TypeSHA1:
-		hasher.hash = sha1.New()
-		hasher.buf = make([]byte, sha1.BlockSize)
-	case TypeSHA256:
-		hasher.hash = sha256.New()
-		hasher.buf = make([]byte, sha256.BlockSize)
+	indexes := rand.New(rand.NewSource(opts.SampleSeed)).Perm(len(checksumFiles))[:n]
+	sort.Ints(indexes)
+
+	sampled := make([]ChecksumFile, n)
+	for i, index := range indexes {
+		sampled[i] = checksumFiles[index]
 	}
 
-	reader := bufio.NewReader(file)
-	for {
-		count, err := reader.Read(hasher.buf)
-		if err != nil {
-			if err != io.EOF {
-				checksumFile.Status = StatusFailedCheckSum
+	return sampled
+}
+
+// FindWithOptions returns the entries of a verification file whose checksum
+// matches the content of file, whatever their names. The file is hashed once
+// for each checksum type in the verification file.
+func FindWithOptions(manifest string, file string, opts Options) []ChecksumFile {
+	checksumFiles := parseSfvFile(manifest, &opts)
+
+	// The file is given on the command line, not read from the manifest
+	fileOpts := opts
+	fileOpts.BaseDir = ""
+
+	checksums := make(map[ChecksumType]string)
+	matches := make([]ChecksumFile, 0)
+	for _, checksumFile := range checksumFiles {
+		checksum, ok := checksums[checksumFile.ChecksumType]
+		if !ok {
+			target := createChecksumFile(checksumFile.ChecksumType, file, &fileOpts)
+			calculateChecksum(&target, newProgress([]ChecksumFile{target}, &fileOpts), &fileOpts)
+			if target.Status != StatusCheckSumOK {
+				log.Fatalf("%s: %s", file, StatusTypeToString(target.Status))
 			}
-			break
-		}
 
-		switch checksumFile.ChecksumType {
-		case TypeCRC32:
-			hasher.hash32.Write(hasher.buf[:count])
-		case TypeMD5:
-			hasher.hash.Write(hasher.buf[:count])
-		case TypeSHA1:
-			hasher.hash.Write(hasher.buf[:count])
-		case TypeSHA256:
-			hasher.hash.Write(hasher.buf[:count])
+			checksum = target.Checksum
+			checksums[checksumFile.ChecksumType] = checksum
 		}
 
-		pb.Add(count)
+		if checksumsEqual(checksumFile.ChecksumType, checksum, checksumFile.ChecksumWant) {
+			matches = append(matches, checksumFile)
+		}
 	}
 
-	switch checksumFile.ChecksumType {
-	case TypeCRC32:
-		checksumFile.Status = StatusCheckSumOK
-		checksumFile.Checksum = fmt.Sprintf("%x", hasher.hash32.Sum32())
-	case TypeMD5:
-		checksumFile.Status = StatusCheckSumOK
-		checksumFile.Checksum = fmt.Sprintf("%x", hasher.hash.Sum(nil))
-	case TypeSHA1:
-		checksumFile.Status = StatusCheckSumOK
-		checksumFile.Checksum = fmt.Sprintf("%x", hasher.hash.Sum(nil))
-	case TypeSHA256:
-		checksumFile.Status = StatusCheckSumOK
-		checksumFile.Checksum = fmt.Sprintf("%x", hasher.hash.Sum(nil))
+	return matches
+}
+
+// Failures returns the entries which didn't verify, with the checksums and
+// sizes they were expected to have, so they can be written to a new file
+func Failures(checksumFiles []ChecksumFile) []ChecksumFile {
+	failures := make([]ChecksumFile, 0)
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status == StatusCheckSumOK {
+			continue
+		}
+
+		checksumFile.Status   = StatusCheckSumOK
+		checksumFile.Checksum = checksumFile.ChecksumWant
+		checksumFile.Filesize = checksumFile.FilesizeWant
+		checksumFile.Metadata = checksumFile.MetadataWant
+		checksumFile.Xattrs   = checksumFile.XattrsWant
+		failures = append(failures, checksumFile)
 	}
+
+	return failures
 }
 
-func verifyChecksumFile(checksumFile *ChecksumFile) {
-	file, err := os.Open(checksumFile.Filename)
-	defer file.Close()
-	if err != nil {
-		checksumFile.Status = StatusNotFound
-		return
+// Duplicates returns the filenames of hashed entries with identical content,
+// one set per checksum shared by more than one entry, in the order found
+func Duplicates(checksumFiles []ChecksumFile) [][]string {
+	checksums := make([]string, 0)
+	filenames := make(map[string][]string)
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Checksum == "" {
+			continue
+		}
+
+		// Checksums of different types never match, even if equal
+		checksum := TypeToString(checksumFile.ChecksumType) + ":" + checksumFile.Checksum
+		if _, ok := filenames[checksum]; !ok {
+			checksums = append(checksums, checksum)
+		}
+		filenames[checksum] = append(filenames[checksum], checksumFile.Filename)
 	}
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		checksumFile.Status = StatusStatFailed
-		return
+	duplicates := make([][]string, 0)
+	for _, checksum := range checksums {
+		if len(filenames[checksum]) > 1 {
+			duplicates = append(duplicates, filenames[checksum])
+		}
 	}
 
-	if fileInfo.IsDir() {
-		checksumFile.Status = StatusNotFile
-		return
+	return duplicates
+}
+
+func compareChecksum(checksumFile *ChecksumFile) {
+	if checksumFile.Status == StatusCheckSumOK &&
+	   !checksumsEqual(checksumFile.ChecksumType, checksumFile.Checksum, checksumFile.ChecksumWant) {
+		checksumFile.Status = StatusCheckSumNoMatch
 	}
+}
 
-	checksumFile.Status   = StatusOK
-	checksumFile.Filesize = fileInfo.Size()
+// checksumsEqual compares two hex checksums of type t. HMACs are compared
+// in constant time, so the time taken doesn't tell how much of a forged one
+// matched.
+func checksumsEqual(t ChecksumType, checksum string, want string) bool {
+	if t == TypeHMACSHA256 {
+		checksumBytes, err := hex.DecodeString(checksum)
+		if err != nil {
+			return false
+		}
+		wantBytes, err := hex.DecodeString(want)
+		if err != nil {
+			return false
+		}
+
+		return hmac.Equal(checksumBytes, wantBytes)
+	}
+
+	// Checksums are calculated in lowercase, but some tools write uppercase
+	return strings.EqualFold(checksum, want)
 }
 
-func createChecksumFile(t ChecksumType, filename string) ChecksumFile {
-	checksumFile := ChecksumFile{t, StatusUnknown, filename, 0, "", ""}
+func parseSfvFile(filename string, opts *Options) []ChecksumFile {
+	var file *os.File
+	var err error
 
-	file, err := os.Open(filename)
-	defer file.Close()
-	if err != nil {
-		checksumFile.Status = StatusNotFound
-	} else {
+	if filename != "" {
+		file, err = os.Open(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer file.Close()
+
+		// Opening a directory succeeds, but reading it doesn't give any lines
 		fileInfo, err := file.Stat()
 		if err != nil {
-			checksumFile.Status = StatusStatFailed
-			goto end
+			log.Fatal(err)
 		}
 
 		if fileInfo.IsDir() {
-			checksumFile.Status = StatusNotFile
-			goto end
+			log.Fatalf("%s: expected a file, got a directory", filename)
+		}
+	} else {
+		file = os.Stdin
+	}
+
+	return parseManifest(file, filename, opts)
+}
+
+// readManifest reads the whole of the verification file filename, or stdin
+// if it's empty
+func readManifest(filename string) []byte {
+	file := os.Stdin
+	if filename != "" {
+		var err error
+		file, err = os.Open(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+	}
+
+	manifest, err := ioutil.ReadAll(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return manifest
+}
+
+// parseManifest parses the verification file filename read from reader and
+// stats the files it lists
+func parseManifest(reader io.Reader, filename string, opts *Options) []ChecksumFile {
+	var checksumFiles []ChecksumFile
+	if isPar2(filename) {
+		checksumFiles = parsePar2Reader(reader, filename)
+	} else {
+		checksumFiles = parseSfvReader(reader, filename, opts)
+	}
+
+	for i, _ := range checksumFiles {
+		checksumFiles[i].Filename = mapFilename(checksumFiles[i].Filename, opts)
+		verifyChecksumFile(&checksumFiles[i], opts)
+		checkFilesize(&checksumFiles[i])
+	}
+
+	return checksumFiles
+}
+
+// checkFilesize marks a file whose size doesn't match its size comment
+func checkFilesize(checksumFile *ChecksumFile) {
+	if checksumFile.Status == StatusOK &&
+	   checksumFile.FilesizeWant != sizeUnknown && checksumFile.Filesize != sizeUnknown &&
+	   checksumFile.Filesize != checksumFile.FilesizeWant {
+		checksumFile.Status = StatusSizeNoMatch
+	}
+}
+
+// parseSfvReader parses the entries of a verification file, filename is only
+// used in messages
+func parseSfvReader(reader io.Reader, filename string, opts *Options) []ChecksumFile {
+	checksumFiles := make([]ChecksumFile, 0)
+	fileSizes := make(map[string]int64)
+
+	// Filenames are opened as UTF-8, whatever the file was written in
+	if opts.InputEncoding != nil {
+		reader = opts.InputEncoding.NewDecoder().Reader(reader)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Split(bufio.ScanLines)
+
+	reSize := regexp.MustCompile(`^; size ([\d]+) (.+)$`)
+	rePathHash := regexp.MustCompile(`^; path-sha256 ([\w]{64}) ([\w]+)$`)
+	reMetadata := regexp.MustCompile(`^; mode ([0-7]{3,4}) uid (-?[\d]+) gid (-?[\d]+) (.+)$`)
+	metadata := make(map[string]*Metadata)
+	reXattrs := regexp.MustCompile(`^; xattr-sha256 ([0-9a-f]{64}) (.+)$`)
+	xattrs := make(map[string]string)
+	pathHashes := make(map[string][]string)
+
+	// A type directive declares the checksum type of the lines following it,
+	// which tells apart types with checksums of the same length
+	reType := regexp.MustCompile(`^; type=([\w-]+)$`)
+	declaredType := TypeUnknown
+
+	manifest := newManifestVerifier()
+
+	lineNumber := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber++
+		manifest.line(line, filename, lineNumber, opts)
+
+		if strings.HasPrefix(line, ";") {
+			if reSize.MatchString(line) {
+				matches := reSize.FindStringSubmatch(line)
+
+				size, err := strconv.ParseInt(matches[1], 10, 64)
+				if err == nil {
+					fileSizes[matches[2]] = size
+				}
+			} else if reMetadata.MatchString(line) {
+				matches := reMetadata.FindStringSubmatch(line)
+
+				mode, _ := strconv.ParseUint(matches[1], 8, 32)
+				uid, _ := strconv.Atoi(matches[2])
+				gid, _ := strconv.Atoi(matches[3])
+				metadata[matches[4]] = &Metadata{uint32(mode), uid, gid}
+			} else if reXattrs.MatchString(line) {
+				matches := reXattrs.FindStringSubmatch(line)
+
+				xattrs[matches[2]] = matches[1]
+			} else if rePathHash.MatchString(line) {
+				matches := rePathHash.FindStringSubmatch(line)
+
+				checksum := strings.ToLower(matches[2])
+				pathHashes[checksum] = append(pathHashes[checksum], matches[1])
+			} else if reType.MatchString(line) {
+				matches := reType.FindStringSubmatch(line)
+
+				declaredType = StringToType(strings.ToLower(matches[1]))
+				if declaredType == TypeUnknown {
+					if opts.Strict {
+						log.Fatalf("%s:%d: unknown type %s", filename, lineNumber, matches[1])
+					}
+					warn("%s:%d: unknown type %s", filename, lineNumber, matches[1])
+				}
+			}
+			continue
+		}
+
+		checksumFile, ok := parseLine(line, filename, opts)
+		if !ok {
+			// SFV filenames which aren't UTF-8 don't match at all, which
+			// would otherwise drop the line without a word
+			if offset := invalidUTF8Offset(line); offset >= 0 {
+				if opts.Strict {
+					log.Fatalf("%s:%d: invalid UTF-8 at byte offset %d", filename, lineNumber, offset)
+				}
+				warn("%s:%d: invalid UTF-8 at byte offset %d, line skipped", filename, lineNumber, offset)
+			}
+
+			// Unknown checksum type
+			continue
+		}
+
+		// Badly transcoded files contain filenames which can't be opened
+		if offset := invalidUTF8Offset(checksumFile.Filename); offset >= 0 {
+			if opts.Strict {
+				log.Fatalf("%s:%d: invalid UTF-8 in filename at byte offset %d", filename, lineNumber, offset)
+			}
+			warn("%s:%d: invalid UTF-8 in filename at byte offset %d", filename, lineNumber, offset)
+		}
+
+		if declaredType != TypeUnknown && checksumFile.ChecksumType != declaredType {
+			width := typeHexWidth(declaredType)
+			if len(checksumFile.ChecksumWant) == width {
+				checksumFile.ChecksumType = declaredType
+			} else {
+				if opts.Strict {
+					log.Fatalf("%s:%d: checksum has %d characters, declared type %s needs %d",
+						filename, lineNumber, len(checksumFile.ChecksumWant), TypeToString(declaredType), width)
+				}
+				warn("%s:%d: checksum has %d characters, declared type %s needs %d",
+					filename, lineNumber, len(checksumFile.ChecksumWant), TypeToString(declaredType), width)
+			}
+		}
+
+		if opts.ForceType != TypeUnknown {
+			checksumFile.ChecksumType = opts.ForceType
+
+			// Every file would fail if the forced type is wrong, so tell why
+			width := typeHexWidth(opts.ForceType)
+			if len(checksumFile.ChecksumWant) != width {
+				if opts.Strict {
+					log.Fatalf("%s:%d: checksum has %d characters, %s needs %d",
+						filename, lineNumber, len(checksumFile.ChecksumWant), TypeToString(opts.ForceType), width)
+				}
+				warn("%s:%d: checksum has %d characters, %s needs %d",
+					filename, lineNumber, len(checksumFile.ChecksumWant), TypeToString(opts.ForceType), width)
+			}
+		}
+
+		checksumFiles = append(checksumFiles, checksumFile)
+	}
+
+	manifest.finish(filename, opts)
+
+	if len(pathHashes) > 0 {
+		checkPathHashes(checksumFiles, pathHashes)
+	}
+
+	if t := typeFromManifestName(filename); t != TypeUnknown && opts.ForceType == TypeUnknown {
+		checkManifestType(checksumFiles, filename, t)
+	}
+
+	// Size and mode comments may appear anywhere in the file, so they can
+	// only be matched once the whole file has been read
+	for i, _ := range checksumFiles {
+		checksumFiles[i].FilesizeWant = sizeUnknown
+		if size, ok := fileSizes[checksumFiles[i].Filename]; ok {
+			checksumFiles[i].FilesizeWant = size
+		}
+
+		checksumFiles[i].MetadataWant = metadata[checksumFiles[i].Filename]
+		checksumFiles[i].XattrsWant = xattrs[checksumFiles[i].Filename]
+	}
+
+	return checksumFiles
+}
+
+// totalSize returns the size of the files left to hash, or sizeUnknown if
+// the size of any of them isn't known
+func totalSize(checksumFiles []ChecksumFile) int64 {
+	var totalFileSize int64
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status != StatusOK {
+			continue
+		}
+
+		if checksumFile.Filesize == sizeUnknown {
+			return sizeUnknown
+		}
+		totalFileSize += checksumFile.Filesize
+	}
+
+	return totalFileSize
+}
+
+// mapFilename replaces TrimPrefix with AddPrefix in a filename from a
+// verification file, to find the files when the paths have moved
+func mapFilename(filename string, opts *Options) string {
+	return opts.AddPrefix + strings.TrimPrefix(filename, opts.TrimPrefix)
+}
+
+// typeFromManifestName returns the checksum type implied by the name of a
+// verification file, like MD5 for MD5SUMS or files.md5
+func typeFromManifestName(filename string) ChecksumType {
+	base := filepath.Base(filename)
+	if strings.HasSuffix(base, "SUMS") {
+		return StringToType(strings.ToLower(strings.TrimSuffix(base, "SUMS")))
+	}
+
+	if filepath.Ext(base) == ".sfv" {
+		return TypeCRC32
+	}
+
+	return StringToType(strings.ToLower(strings.TrimPrefix(filepath.Ext(base), ".")))
+}
+
+// checkManifestType warns if entries aren't of the type the name of the
+// verification file implies, which often means the wrong file was given
+func checkManifestType(checksumFiles []ChecksumFile, filename string, t ChecksumType) {
+	mismatches := 0
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.ChecksumType != t {
+			mismatches++
+		}
+	}
+
+	if mismatches > 0 {
+		warn("%s: the name suggests %s, but %d of %d entries are of another type",
+			filename, TypeToString(t), mismatches, len(checksumFiles))
+	}
+}
+
+// checkPathHashes warns about entries which were renamed or added after the
+// file was created, using the path hashes recorded for each checksum
+func checkPathHashes(checksumFiles []ChecksumFile, pathHashes map[string][]string) {
+	for _, checksumFile := range checksumFiles {
+		hashes, ok := pathHashes[strings.ToLower(checksumFile.ChecksumWant)]
+		if !ok {
+			warn("%s: new entry, not in the original file", checksumFile.Filename)
+			continue
+		}
+
+		pathHash := pathHash(checksumFile.Filename)
+		renamed := true
+		for _, hash := range hashes {
+			if hash == pathHash {
+				renamed = false
+				break
+			}
+		}
+
+		if renamed {
+			warn("%s: renamed entry, the content was originally listed under another name", checksumFile.Filename)
+		}
+	}
+}
+
+func pathHash(filename string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(filename)))
+}
+
+// invalidUTF8Offset returns the byte offset of the first invalid UTF-8
+// sequence in s, or -1 if s is valid
+func invalidUTF8Offset(s string) int {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+
+	return -1
+}
+
+func warn(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", a...)
+}
+
+// isSfvName tells whether a verification file is named like an SFV file
+func isSfvName(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".sfv")
+}
+
+// parseLine parses a checksum line of the verification file manifest using
+// the first matching lineParser
+func parseLine(line string, manifest string, opts *Options) (ChecksumFile, bool) {
+	parsers := lineParsers
+	if opts.Encoding == EncodingBase64 {
+		parsers = base64LineParsers
+	}
+
+	var checksumFile ChecksumFile
+	for _, parser := range parsers {
+		matches := parser.re.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		checksumFile.ChecksumType = parser.checksumType
+		if parser.typeGroup > 0 {
+			checksumFile.ChecksumType = StringToType(strings.ToLower(matches[parser.typeGroup]))
+		}
+		checksumFile.Filename     = matches[parser.nameGroup]
+		checksumFile.ChecksumWant = matches[parser.hashGroup]
+
+		if parser.integrity {
+			integrityType, checksum, ok := parseIntegrity(checksumFile.ChecksumWant)
+			if !ok {
+				return checksumFile, false
+			}
+
+			checksumFile.ChecksumType = integrityType
+			checksumFile.ChecksumWant = checksum
+			return checksumFile, len(checksum) == typeHexWidth(integrityType)
+		}
+
+		// "hash name" lines of a file named like a CRC32 also look like
+		// "name hash", but their name is a checksum. Only .sfv files have
+		// CRC32s after names, which may be named like a CRC32 themselves.
+		if parser.nameGroup < parser.hashGroup {
+			if t := typeOfChecksum(checksumFile.Filename, opts.Encoding); t != TypeUnknown &&
+			   (t != TypeCRC32 || !isSfvName(manifest)) {
+				continue
+			}
+		}
+
+		if opts.Encoding != EncodingHex {
+			checksum, ok := decodeChecksum(checksumFile.ChecksumWant, opts.Encoding)
+			if !ok {
+				return checksumFile, false
+			}
+
+			checksumFile.ChecksumWant = checksum
+			if parser.checksumType == TypeUnknown && parser.typeGroup == 0 {
+				checksumFile.ChecksumType = typeOfWidth(len(checksum))
+			}
+		}
+
+		if checksumFile.ChecksumType == TypeUnknown ||
+		   len(checksumFile.ChecksumWant) != typeHexWidth(checksumFile.ChecksumType) {
+			return checksumFile, false
+		}
+
+		return checksumFile, true
+	}
+
+	if opts.Encoding == EncodingHex {
+		return parseCoreutilsLine(line)
+	}
+
+	return checksumFile, false
+}
+
+func WriteToFile(checksumFiles []ChecksumFile, filename string) {
+	WriteToFileWithOptions(checksumFiles, filename, DefaultOptions())
+}
+
+func WriteToFileWithOptions(checksumFiles []ChecksumFile, filename string, opts Options) {
+	if filename == "" {
+		err := writeManifest(os.Stdout, nil, checksumFiles, &opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if opts.Append {
+		appendToFile(checksumFiles, filename, opts)
+		return
+	}
+
+	// Write to a temporary file in the same directory and rename it into
+	// place when done, so an interrupted write never leaves a corrupt file
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writeManifest(file, nil, checksumFiles, &opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		var mode os.FileMode = 0644
+		if fileInfo, statErr := os.Stat(filename); statErr == nil {
+			mode = fileInfo.Mode()
+		}
+		err = os.Chmod(file.Name(), mode)
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		log.Fatal(err)
+	}
+}
+
+// appendToFile adds entries to the end of an existing file, leaving out the
+// header unless the file is new and entries which are already listed
+func appendToFile(checksumFiles []ChecksumFile, filename string, opts Options) {
+	parsed, _ := os.Stat(filename)
+	listed := listedFilenames(filename, &opts)
+	if len(listed) > 0 {
+		checksumFiles = append([]ChecksumFile(nil), checksumFiles...)
+		for i, _ := range checksumFiles {
+			if checksumFiles[i].Status == StatusCheckSumOK && listed[checksumFiles[i].Filename] {
+				warn("%s: already in %s, not appended again", checksumFiles[i].Filename, filename)
+				checksumFiles[i].Status = StatusUnknown
+			}
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The listed entries would be stale, and another process rewriting the
+	// file would lose what's appended
+	if manifestChanged(filename, parsed) {
+		file.Close()
+		log.Fatalf("%s: manifest changed on disk", filename)
+	}
+
+	fileInfo, err := file.Stat()
+	if err == nil {
+		if fileInfo.Size() > 0 {
+			opts.WriteHeader = false
+		}
+		err = writeManifest(file, file, checksumFiles, &opts)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// manifestChanged tells whether filename was replaced or modified since it
+// was stat'ed as before, which is nil if it didn't exist then
+func manifestChanged(filename string, before os.FileInfo) bool {
+	after, err := os.Stat(filename)
+	if before == nil {
+		return err == nil && after.Size() > 0
+	} else if err != nil {
+		return true
+	}
+
+	return !os.SameFile(before, after) ||
+	       !after.ModTime().Equal(before.ModTime()) ||
+	       after.Size() != before.Size()
+}
+
+// listedFilenames returns the filenames of the entries in a verification
+// file, or none if the file doesn't exist yet
+func listedFilenames(filename string, opts *Options) map[string]bool {
+	listed := make(map[string]bool)
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return listed
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if checksumFile, ok := parseLine(scanner.Text(), filename, opts); ok {
+			listed[checksumFile.Filename] = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return listed
+}
+
+// WriteToDirs writes one file per directory, each listing only the files in
+// that directory by their base name.
+func WriteToDirs(checksumFiles []ChecksumFile, filename string, opts Options) {
+	dirs := make([]string, 0)
+	dirFiles := make(map[string][]ChecksumFile)
+	for _, checksumFile := range checksumFiles {
+		dir := filepath.Dir(checksumFile.Filename)
+		if _, ok := dirFiles[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+
+		checksumFile.Filename = filepath.Base(checksumFile.Filename)
+		dirFiles[dir] = append(dirFiles[dir], checksumFile)
+	}
+
+	for _, dir := range dirs {
+		WriteToFileWithOptions(dirFiles[dir], opts.path(filepath.Join(dir, filepath.Base(filename))), opts)
+	}
+}
+
+// checksumWriter is where verification files are written, a file or a buffer
+type checksumWriter interface {
+	io.Writer
+	io.StringWriter
+}
+
+func writeChecksumFiles(file checksumWriter, checksumFiles []ChecksumFile, opts *Options) error {
+	if opts.WriteHeader {
+		if err := writeHeader(file, opts); err != nil {
+			return err
+		}
+	}
+
+	if hasQuick(checksumFiles) {
+		_, err := file.WriteString("; QUICK entries only fingerprint the size, first and last 4 MiB of files, they aren't full hashes\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.LineTemplate == nil {
+		for _, checksumFile := range checksumFiles {
+			if checksumFile.Status == StatusCheckSumOK {
+				if err := writeComments(file, checksumFile, opts); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status == StatusCheckSumOK {
+			if err := writeChecksumLine(file, checksumFile, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeHeader(file checksumWriter, opts *Options) error {
+	header := fmt.Sprintf("; Generated by gosfv version %s(%s)", Version, Commit)
+	if opts.HeaderTimeFormat != "" {
+		now := time.Now().UTC()
+		if opts.HeaderLocalTime {
+			now = time.Now()
+		}
+
+		header += " at " + now.Format(opts.HeaderTimeFormat)
+	}
+
+	_, err := file.WriteString(header + "\n")
+	return err
+}
+
+// writeComments writes the size, metadata and path hash comments of an entry
+func writeComments(file checksumWriter, checksumFile ChecksumFile, opts *Options) error {
+	if checksumFile.Filesize != sizeUnknown {
+		_, err := file.WriteString(fmt.Sprintf("; size %d %s\n", checksumFile.Filesize, checksumFile.Filename))
+		if err != nil {
+			return err
+		}
+	}
+
+	if checksumFile.Metadata != nil {
+		_, err := file.WriteString(fmt.Sprintf("; %s %s\n", checksumFile.Metadata, checksumFile.Filename))
+		if err != nil {
+			return err
+		}
+	}
+
+	if checksumFile.Xattrs != "" {
+		_, err := file.WriteString(fmt.Sprintf("; xattr-sha256 %s %s\n", checksumFile.Xattrs, checksumFile.Filename))
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.WritePathHashes {
+		_, err := file.WriteString(fmt.Sprintf("; path-sha256 %s %s\n", pathHash(checksumFile.Filename), checksumFile.Checksum))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeChecksumLine writes an entry in the LineTemplate format if set, or
+// else in the format of its checksum type
+func writeChecksumLine(file checksumWriter, checksumFile ChecksumFile, opts *Options) error {
+	integrity := Integrity(checksumFile.ChecksumType, checksumFile.Checksum)
+	checksumFile.Checksum = encodeChecksum(checksumFile.Checksum, opts.Encoding)
+
+	if opts.LineTemplate != nil {
+		return opts.LineTemplate.Execute(file, lineData{
+			Filename:  checksumFile.Filename,
+			Checksum:  checksumFile.Checksum,
+			Size:      checksumFile.Filesize,
+			Type:      TypeToString(checksumFile.ChecksumType),
+			Integrity: integrity,
+		})
+	}
+
+	var err error
+	switch checksumFile.ChecksumType {
+	case TypeCRC32:
+		_, err = file.WriteString(fmt.Sprintf("%s %s\n", checksumFile.Filename, checksumFile.Checksum))
+	case TypeMD5:
+		_, err = file.WriteString(fmt.Sprintf("MD5 (%s) = %s\n", checksumFile.Filename, checksumFile.Checksum))
+	case TypeSHA1:
+		_, err = file.WriteString(fmt.Sprintf("%s  %s\n", checksumFile.Checksum, checksumFile.Filename))
+	case TypeSHA256:
+		_, err = file.WriteString(fmt.Sprintf("%s  %s\n", checksumFile.Checksum, checksumFile.Filename))
+	case TypeSHA512_256:
+		// Same digest length as SHA256, so it needs the algorithm tag
+		_, err = file.WriteString(fmt.Sprintf("SHA512-256 (%s) = %s\n", checksumFile.Filename, checksumFile.Checksum))
+	case TypeHMACSHA256:
+		_, err = file.WriteString(fmt.Sprintf("HMAC-SHA256 (%s) = %s\n", checksumFile.Filename, checksumFile.Checksum))
+	case TypeSHA384, TypeSHA512:
+		_, err = file.WriteString(fmt.Sprintf("%s  %s\n", checksumFile.Checksum, checksumFile.Filename))
+	default:
+		// Same digest length as MD5 for ED2K, and registered types can only
+		// be told apart by the algorithm tag
+		_, err = file.WriteString(fmt.Sprintf("%s (%s) = %s\n",
+			strings.ToUpper(TypeToString(checksumFile.ChecksumType)), checksumFile.Filename, checksumFile.Checksum))
+	}
+
+	return err
+}
+
+func calculateChecksum(checksumFile *ChecksumFile, progress *progress, opts *Options) {
+	if checksumFile.Status != StatusOK {
+		return
+	}
+
+	if stopped(opts.Stop) {
+		checksumFile.Status = StatusInterrupted
+		return
+	}
+
+	// Files which don't exist were found as parts when stat'ed
+	if opts.JoinParts {
+		if _, err := os.Stat(opts.path(checksumFile.Filename)); os.IsNotExist(err) {
+			reader := &partsReader{parts: partNames(checksumFile.Filename, opts), opts: opts}
+			defer reader.Close()
+
+			calculateReaderChecksum(checksumFile, reader, progress, opts)
+			return
+		}
+	}
+
+	// Initial stat of file have already been handled, so no need to verify errors
+	// of opening the file
+	file := os.Stdin
+	if checksumFile.Filename != stdinFilename {
+		file, _ = os.Open(opts.path(checksumFile.Filename))
+		defer file.Close()
+	}
+
+	// Quick fingerprints skip the middle of files big enough to have one
+	if checksumFile.ChecksumType == TypeQuick && file != os.Stdin && checksumFile.Filesize > 2*quickChunkSize &&
+		!(opts.Decompress && isCompressed(checksumFile.Filename)) {
+		calculateQuickChecksum(checksumFile, file, progress)
+		return
+	}
+
+	if opts.ResumeState != "" && file != os.Stdin && checksumFile.Filesize != sizeUnknown &&
+		!(opts.Decompress && isCompressed(checksumFile.Filename)) {
+		if calculateResumableChecksum(checksumFile, file, progress, opts) {
+			return
+		}
+	}
+
+	// The size is needed to know when to stop skipping unreadable blocks
+	if opts.SkipReadErrors && file != os.Stdin && checksumFile.Filesize != sizeUnknown {
+		reader := &tolerantReader{file: file, size: checksumFile.Filesize}
+		calculateReaderChecksum(checksumFile, reader, progress, opts)
+
+		if len(reader.bad) > 0 && checksumFile.Status == StatusCheckSumOK {
+			checksumFile.Status     = StatusPartialRead
+			checksumFile.BadRegions = reader.bad
+		}
+		return
+	}
+
+	calculateReaderChecksum(checksumFile, file, progress, opts)
+}
+
+// calculateReaderChecksum hashes the content of a file read from file, which
+// doesn't have to be on disk
+func calculateReaderChecksum(checksumFile *ChecksumFile, file io.Reader, progress *progress, opts *Options) {
+	if checksumFile.Status != StatusOK {
+		return
+	}
+	defer progress.done(checksumFile.Filename)
+
+	if checksumFile.ChecksumType == TypeHMACSHA256 && len(opts.HMACKey) == 0 {
+		checksumFile.Status = StatusMissingKey
+		return
+	}
+
+	// Progress and size are counted on the file itself, not on what is hashed
+	// which differs for compressed files
+	counter := &progressReader{reader: bufio.NewReader(file), progress: progress, filename: checksumFile.Filename,
+		size: checksumFile.Filesize}
+
+	var reader io.Reader = counter
+	if opts.Decompress && isCompressed(checksumFile.Filename) {
+		decompressor, err := newDecompressor(checksumFile.Filename, counter)
+		if err != nil {
+			warn("%s: %v", checksumFile.Filename, err)
+			checksumFile.Status = StatusFailedCheckSum
+			return
+		}
+		defer decompressor.Close()
+
+		reader = decompressor
+	}
+
+	start := time.Now()
+	checksum, err := hashReader(checksumFile.ChecksumType, reader, opts)
+	if err == errInterrupted {
+		checksumFile.Status = StatusInterrupted
+		return
+	} else if err != nil {
+		checksumFile.Status = StatusFailedCheckSum
+		return
+	}
+
+	// Another process might have truncated or appended to the file since it
+	// was stat'ed, which would otherwise give a checksum of the wrong data
+	if checksumFile.Filesize == sizeUnknown {
+		checksumFile.Filesize = counter.count
+	} else if counter.count != checksumFile.Filesize {
+		checksumFile.Status = StatusSizeChanged
+		return
+	}
+
+	checksumFile.Status   = StatusCheckSumOK
+	checksumFile.Checksum = checksum
+
+	if opts.TimingsWriter != nil {
+		writeTiming(opts.TimingsWriter, checksumFile.Filename, counter.count, progress.started, start)
+	}
+}
+
+// writeTiming writes when a file started hashing relative to the start of the
+// run, how long it took and the throughput, which points out files slowing
+// down a run, like those on a failing disk, and lines them up with the
+// system logs
+func writeTiming(w io.Writer, filename string, size int64, runStart time.Time, start time.Time) {
+	elapsed := time.Since(start)
+
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(size) / 1e6 / elapsed.Seconds()
+	}
+
+	fmt.Fprintf(w, "%s  %s  %d  %s  %.1f MB/s\n", relativeTime(runStart, start), filename, size,
+		elapsed.Round(time.Microsecond), throughput)
+}
+
+// relativeTime formats how long after runStart t is as +hh:mm:ss, files
+// hashed again outside of a run have no start
+func relativeTime(runStart time.Time, t time.Time) string {
+	if runStart.IsZero() {
+		return "+--:--:--"
+	}
+
+	seconds := int64(t.Sub(runStart).Seconds())
+	return fmt.Sprintf("+%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// HashReader returns the checksum of type t of everything read from reader
+func HashReader(t ChecksumType, reader io.Reader, opts Options) (string, error) {
+	return hashReader(t, reader, &opts)
+}
+
+func hashReader(t ChecksumType, reader io.Reader, opts *Options) (string, error) {
+	hasher := newHash(t, opts)
+
+	bufPtr := getBuffer(opts.BufferSize)
+	defer bufferPool.Put(bufPtr)
+	buf := *bufPtr
+
+	for {
+		if stopped(opts.Stop) {
+			return "", errInterrupted
+		}
+
+		count, err := reader.Read(buf)
+		hasher.Write(buf[:count])
+
+		if err != nil {
+			if err != io.EOF {
+				return "", err
+			}
+			break
+		}
+	}
+
+	return formatChecksum(t, hasher), nil
+}
+
+// errInterrupted is returned by hashReader when Options.Stop is closed
+var errInterrupted = errors.New("interrupted")
+
+// stopped tells whether stop has been closed, a nil stop never is
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// bufferPool recycles read buffers between files, to not allocate a new one
+// for every file hashed
+var bufferPool sync.Pool
+
+// getBuffer returns a read buffer of size bytes, or of defaultBufferSize if
+// size isn't positive
+func getBuffer(size int) *[]byte {
+	if size < 1 {
+		size = defaultBufferSize
+	}
+
+	if buf, ok := bufferPool.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+
+	buf := make([]byte, size)
+	return &buf
+}
+
+// formatChecksum hex encodes the sum of hasher, padded with zeros to the
+// width of checksum type t. Numeric sums like CRC32 are formatted the same
+// way, so they never lose their leading zeros.
+func formatChecksum(t ChecksumType, hasher hash.Hash) string {
+	checksum := fmt.Sprintf("%x", hasher.Sum(nil))
+	if width := typeHexWidth(t); len(checksum) < width {
+		checksum = strings.Repeat("0", width-len(checksum)) + checksum
+	}
+
+	return checksum
+}
+
+func verifyChecksumFile(checksumFile *ChecksumFile, opts *Options) {
+	// Opening follows symlinks, so check for them first
+	linkInfo, err := os.Lstat(opts.path(checksumFile.Filename))
+	if err == nil && linkInfo.Mode()&os.ModeSymlink != 0 {
+		checksumFile.Symlink = true
+
+		if opts.NoSymlinks {
+			checksumFile.Status = StatusSymlinkSkipped
+			return
+		}
+	}
+
+	file, err := os.Open(opts.path(checksumFile.Filename))
+	defer file.Close()
+	if os.IsNotExist(err) && opts.JoinParts && statParts(checksumFile, opts) {
+		return
+	} else if err != nil {
+		checksumFile.Status = StatusNotFound
+		return
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		checksumFile.Status = StatusStatFailed
+		return
+	}
+
+	if fileInfo.IsDir() {
+		checksumFile.Status = StatusNotFile
+		return
+	}
+
+	checksumFile.Status   = StatusOK
+	checksumFile.Filesize = fileSize(fileInfo)
+
+	if checksumFile.MetadataWant != nil {
+		checksumFile.Metadata = fileMetadata(fileInfo)
+	}
+	if checksumFile.XattrsWant != "" {
+		checksumFile.Xattrs, _ = fileXattrs(opts.path(checksumFile.Filename))
+	}
+}
+
+// fileSize returns the size of a stat'ed file, or sizeUnknown for pipes and
+// devices which have no size until they've been read
+func fileSize(fileInfo os.FileInfo) int64 {
+	if !fileInfo.Mode().IsRegular() {
+		return sizeUnknown
+	}
+
+	return fileInfo.Size()
+}
+
+func createChecksumFile(t ChecksumType, filename string, opts *Options) ChecksumFile {
+	checksumFile := ChecksumFile{t, StatusUnknown, filename, 0, "", "", 0, false, nil, nil, "", "", nil}
+
+	if filename == stdinFilename {
+		// Pipes can't be stat'ed for their size, it's counted while hashing
+		checksumFile.Status   = StatusOK
+		checksumFile.Filesize = sizeUnknown
+		return checksumFile
+	}
+
+	file, err := os.Open(opts.path(filename))
+	defer file.Close()
+	if err != nil {
+		checksumFile.Status = StatusNotFound
+	} else {
+		fileInfo, err := file.Stat()
+		if err != nil {
+			checksumFile.Status = StatusStatFailed
+			goto end
+		}
+
+		if fileInfo.IsDir() {
+			checksumFile.Status = StatusNotFile
+			goto end
+		}
+
+		if !opts.Since.IsZero() && fileInfo.ModTime().Before(opts.Since) {
+			checksumFile.Status = StatusNotModified
+			goto end
+		}
+
+		checksumFile.Status   = StatusOK
+		checksumFile.Filesize = fileSize(fileInfo)
+
+		if opts.WriteMetadata {
+			checksumFile.Metadata = fileMetadata(fileInfo)
+		}
+		if opts.WriteXattrs {
+			if xattrs, ok := fileXattrs(opts.path(filename)); ok {
+				checksumFile.Xattrs = xattrs
+			} else {
+				warn("%s: extended attributes not supported, not recorded", filename)
+			}
 		}
-
-		checksumFile.Status   = StatusOK
-		checksumFile.Filesize = fileInfo.Size()
 	}
 
 end:
@@ -0,0 +1,331 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry is the part of a parsed ChecksumFile the parsing tests compare
+type entry struct {
+	checksumType ChecksumType
+	filename     string
+	checksum     string
+}
+
+// parseFixture parses a verification file in testdata
+func parseFixture(t *testing.T, name string, opts Options) []entry {
+	t.Helper()
+
+	var entries []entry
+	for _, checksumFile := range parseSfvFile(filepath.Join("testdata", name), &opts) {
+		entries = append(entries, entry{checksumFile.ChecksumType, checksumFile.Filename, checksumFile.ChecksumWant})
+	}
+
+	return entries
+}
+
+func checkEntries(t *testing.T, name string, got, want []entry) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d entries %v, want %d", name, len(got), got, len(want))
+	}
+
+	for i, _ := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: entry %d is %v, want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseSeparators(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []entry
+	}{
+		{"tabs.sfv", []entry{
+			{TypeCRC32, "hello.txt", "363a3020"},
+			{TypeCRC32, "world.txt", "0a1b2c3d"},
+		}},
+		// SFV files may list files named like a CRC32
+		{"hexnames.sfv", []entry{
+			{TypeCRC32, "20211231", "363a3020"},
+			{TypeCRC32, "deadbeef", "0a1b2c3d"},
+		}},
+		{"spaces.sfv", []entry{
+			{TypeCRC32, "hello.txt", "363a3020"},
+			{TypeCRC32, "world.txt", "0A1B2C3D"},
+		}},
+		// Names which fit a CRC32 mustn't take the hash for the name
+		{"sha256-short-names.sha256", []entry{
+			{TypeSHA256, "Makefile", "2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881"},
+			{TypeSHA256, "deadbeef", "a1fce4363854ff888cff4b8e7875d600c2682390412a8cf79b37d0b11148b0fa"},
+		}},
+	}
+
+	for _, test := range tests {
+		checkEntries(t, test.fixture, parseFixture(t, test.fixture, DefaultOptions()), test.want)
+	}
+}
+
+func TestSizeChangedWhileReading(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "growing.log")
+	if err := ioutil.WriteFile(filename, bytes.Repeat([]byte("x"), 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncated by another process once the first block is read
+	truncated := false
+	opts := DefaultOptions()
+	opts.BufferSize = 4096
+	opts.ProgressFunc = func(_ string, done, _ int64) {
+		if !truncated && done > 0 {
+			truncated = true
+			if err := os.Truncate(filename, 8192); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	checksumFiles := CreateWithOptions(TypeSHA256, []string{filename}, opts)
+	if status := checksumFiles[0].Status; status != StatusSizeChanged {
+		t.Errorf("status is %s, want %s", StatusTypeToString(status), StatusTypeToString(StatusSizeChanged))
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	sha1   := "f572d396fae9206628714fb2ce00f72e94f2258f"
+	sha256 := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	md5    := "b1946ac92492d2347c6235b4d2611184"
+
+	tests := []struct {
+		line string
+		ok   bool
+		want entry
+	}{
+		{"hello.txt 363a3020", true, entry{TypeCRC32, "hello.txt", "363a3020"}},
+		{"MD5 (hello.txt) = " + md5, true, entry{TypeMD5, "hello.txt", md5}},
+		{"SHA256 (hello.txt) = " + sha256, true, entry{TypeSHA256, "hello.txt", sha256}},
+		{sha1 + "  hello.txt", true, entry{TypeSHA1, "hello.txt", sha1}},
+		{sha256 + "  hello.txt", true, entry{TypeSHA256, "hello.txt", sha256}},
+		{"hello.txt 363a302", false, entry{}},
+		{"SHA256 (hello.txt) = " + md5, false, entry{}},
+		{"not a checksum line", false, entry{}},
+	}
+
+	opts := DefaultOptions()
+	for _, test := range tests {
+		checksumFile, ok := parseLine(test.line, "test.sfv", &opts)
+		if ok != test.ok {
+			t.Errorf("%q: parsed %v, want %v", test.line, ok, test.ok)
+			continue
+		}
+
+		got := entry{checksumFile.ChecksumType, checksumFile.Filename, checksumFile.ChecksumWant}
+		if ok && got != test.want {
+			t.Errorf("%q: got %v, want %v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.BaseDir = dir
+	opts.HMACKey = []byte("secret")
+
+	for _, checksumType := range checksumTypes {
+		name := TypeToString(checksumType)
+		manifest := filepath.Join(dir, "checksums."+name)
+
+		created := CreateWithOptions(checksumType, []string{"hello.txt"}, opts)
+		if created[0].Status != StatusCheckSumOK {
+			t.Errorf("%s: create gave %s", name, StatusTypeToString(created[0].Status))
+			continue
+		}
+		WriteToFileWithOptions(created, manifest, opts)
+
+		verified := VerifyWithOptions(manifest, opts)
+		if len(verified) != 1 {
+			t.Errorf("%s: verified %d entries, want 1", name, len(verified))
+			continue
+		}
+		if verified[0].ChecksumType != checksumType || verified[0].Status != StatusCheckSumOK {
+			t.Errorf("%s: verified as %s with %s", name, TypeToString(verified[0].ChecksumType),
+				StatusTypeToString(verified[0].Status))
+		}
+	}
+}
+
+func TestDefaultManifestName(t *testing.T) {
+	tests := []struct {
+		checksumType ChecksumType
+		want         string
+	}{
+		{TypeCRC32, "checksums.sfv"},
+		{TypeMD5, "checksums.md5"},
+		{TypeSHA1, "checksums.sha1"},
+		{TypeSHA256, "checksums.sha256"},
+		{TypeSHA512_256, "checksums.sha512-256"},
+		{TypeHMACSHA256, "checksums.hmac-sha256"},
+		{TypeSHA384, "checksums.sha384"},
+		{TypeSHA512, "checksums.sha512"},
+		{TypeED2K, "checksums.ed2k"},
+		{TypeQuick, "checksums.quick"},
+		{TypeUnknown, "checksums.txt"},
+	}
+
+	if len(tests) != len(checksumTypes)+1 {
+		t.Fatalf("%d types tested, want all %d and TypeUnknown", len(tests), len(checksumTypes))
+	}
+
+	for _, test := range tests {
+		if got := DefaultManifestName(test.checksumType); got != test.want {
+			t.Errorf("%s: got %s, want %s", TypeToString(test.checksumType), got, test.want)
+		}
+
+		// Verifying the file tells the type by its name again
+		if test.checksumType != TypeUnknown && test.checksumType != TypeCRC32 {
+			if got := typeFromManifestName(test.want); got != test.checksumType {
+				t.Errorf("%s: read back as %s", test.want, TypeToString(got))
+			}
+		}
+	}
+}
+
+// shortSum is a hash whose sum lost its leading zero bytes, like a number
+// formatted without padding
+type shortSum struct{}
+
+func (shortSum) Write(p []byte) (int, error) { return len(p), nil }
+func (shortSum) Sum(b []byte) []byte          { return append(b, 0xab, 0xcd) }
+func (shortSum) Reset()                       {}
+func (shortSum) Size() int                    { return 2 }
+func (shortSum) BlockSize() int               { return 1 }
+
+func TestCRC32LeadingZeros(t *testing.T) {
+	opts := DefaultOptions()
+
+	// The CRC32 of 665 is 0x00081566
+	checksum, err := hashReader(TypeCRC32, strings.NewReader("665"), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum != "00081566" {
+		t.Errorf("CRC32 of 665 is %s, want 00081566", checksum)
+	}
+
+	if checksum := formatChecksum(TypeCRC32, shortSum{}); checksum != "0000abcd" {
+		t.Errorf("short sum formatted as %s, want 0000abcd", checksum)
+	}
+}
+
+func TestChecksumWidth(t *testing.T) {
+	opts := DefaultOptions()
+	opts.HMACKey = []byte("secret")
+
+	inputs := []string{"", "665", "hello\n", strings.Repeat("x", 100000)}
+	for _, checksumType := range checksumTypes {
+		for _, input := range inputs {
+			checksum, err := hashReader(checksumType, strings.NewReader(input), &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(checksum) != typeHexWidth(checksumType) {
+				t.Errorf("%s of %d bytes is %s, %d characters instead of %d", TypeToString(checksumType),
+					len(input), checksum, len(checksum), typeHexWidth(checksumType))
+			}
+		}
+	}
+}
+
+func TestVerifyUppercase(t *testing.T) {
+	opts := DefaultOptions()
+	opts.BaseDir = "testdata"
+
+	for _, fixture := range []string{"uppercase.sha256", "uppercase-tag.sha256"} {
+		checksumFiles := VerifyWithOptions(filepath.Join("testdata", fixture), opts)
+		if len(checksumFiles) != 1 {
+			t.Errorf("%s: verified %d entries, want 1", fixture, len(checksumFiles))
+			continue
+		}
+
+		if status := checksumFiles[0].Status; status != StatusCheckSumOK {
+			t.Errorf("%s: status is %s, want %s", fixture, StatusTypeToString(status), StatusTypeToString(StatusCheckSumOK))
+		}
+	}
+}
+
+func TestChecksumsEqual(t *testing.T) {
+	tests := []struct {
+		t        ChecksumType
+		checksum string
+		want     string
+		equal    bool
+	}{
+		{TypeCRC32, "363a3020", "363A3020", true},
+		{TypeCRC32, "363a3020", "363a3021", false},
+		{TypeHMACSHA256, "9c196e32dc0175f86f4b1cb89289d6619de6bee699e4c378e68309ed97a1a6ab",
+			"9C196E32DC0175F86F4B1CB89289D6619DE6BEE699E4C378E68309ED97A1A6AB", true},
+		{TypeHMACSHA256, "9c196e32dc0175f86f4b1cb89289d6619de6bee699e4c378e68309ed97a1a6ab",
+			"9c196e32dc0175f86f4b1cb89289d6619de6bee699e4c378e68309ed97a1a6ac", false},
+		{TypeHMACSHA256, "9c196e32dc0175f86f4b1cb89289d6619de6bee699e4c378e68309ed97a1a6ab", "not hex", false},
+	}
+
+	for _, test := range tests {
+		if equal := checksumsEqual(test.t, test.checksum, test.want); equal != test.equal {
+			t.Errorf("%s %s and %s: equal is %t, want %t", TypeToString(test.t), test.checksum, test.want, equal, test.equal)
+		}
+	}
+}
+
+func TestInvalidUTF8InComment(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Strict = true
+
+	// Only filenames need to be UTF-8, strict parsing would exit otherwise
+	manifest := "; saved by a tool writing Latin-1: caf\xe9\nhello.txt 363a3020\n"
+	checksumFiles := parseSfvReader(strings.NewReader(manifest), "test.sfv", &opts)
+	if len(checksumFiles) != 1 || checksumFiles[0].Filename != "hello.txt" {
+		t.Errorf("parsed %+v, want hello.txt", checksumFiles)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SidecarName returns the name of the sidecar file with a checksum of type t
+// of filename, like movie.mkv.sha256 for movie.mkv
+func SidecarName(filename string, t ChecksumType) string {
+	return filename + filepath.Ext(DefaultManifestName(t))
+}
+
+// WriteSidecars writes one file next to each file, listing only that file by
+// its base name. Files which weren't hashed get no sidecar.
+func WriteSidecars(checksumFiles []ChecksumFile, opts Options) {
+	for _, checksumFile := range checksumFiles {
+		if checksumFile.Status != StatusCheckSumOK {
+			continue
+		}
+
+		sidecar := opts.path(SidecarName(checksumFile.Filename, checksumFile.ChecksumType))
+		checksumFile.Filename = filepath.Base(checksumFile.Filename)
+		WriteToFileWithOptions([]ChecksumFile{checksumFile}, sidecar, opts)
+	}
+}
+
+// VerifySidecarsWithOptions verifies each of files against the sidecar file
+// next to it, the first found of any checksum type. Files without a sidecar,
+// or whose sidecar doesn't list them, get StatusNoSidecar.
+func VerifySidecarsWithOptions(files []string, opts Options) []ChecksumFile {
+	checksumFiles := make([]ChecksumFile, len(files))
+	for i, file := range files {
+		checksumFiles[i] = parseSidecar(file, &opts)
+		if checksumFiles[i].Status == StatusNoSidecar {
+			continue
+		}
+
+		verifyChecksumFile(&checksumFiles[i], &opts)
+		checkFilesize(&checksumFiles[i])
+	}
+
+	hashEntries(checksumFiles, &opts)
+
+	return checksumFiles
+}
+
+// parseSidecar returns the entry of file in its sidecar file
+func parseSidecar(filename string, opts *Options) ChecksumFile {
+	missing := ChecksumFile{TypeUnknown, StatusNoSidecar, filename, 0, "", "", sizeUnknown, false, nil, nil, "", "", nil}
+
+	for _, t := range checksumTypes {
+		sidecar := SidecarName(filename, t)
+		file, err := os.Open(opts.path(sidecar))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+
+		for _, checksumFile := range parseSfvReader(file, sidecar, opts) {
+			if checksumFile.Filename == filepath.Base(filename) {
+				checksumFile.Filename = filename
+				return checksumFile
+			}
+		}
+
+		warn("%s: no entry for %s", sidecar, filepath.Base(filename))
+		return missing
+	}
+
+	return missing
+}
+
+// SidecarFiles returns the files in the trees under dirs, leaving out the
+// sidecar files next to them, so they can be given to
+// VerifySidecarsWithOptions. Files without a sidecar are included.
+func SidecarFiles(dirs []string, opts Options) []string {
+	files := make([]string, 0)
+	for _, dir := range dirs {
+		root := opts.path(dir)
+		err := walkTree(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				warn("%s: %s", path, err)
+				return nil
+			}
+
+			if info.IsDir() || isSidecar(path) {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+
+			files = append(files, filepath.Join(dir, rel))
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return files
+}
+
+// isSidecar tells whether path is the sidecar file of a file next to it
+func isSidecar(path string) bool {
+	for _, t := range checksumTypes {
+		ext := filepath.Ext(DefaultManifestName(t))
+		if !strings.HasSuffix(path, ext) {
+			continue
+		}
+
+		if _, err := os.Stat(strings.TrimSuffix(path, ext)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
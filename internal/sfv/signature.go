@@ -0,0 +1,89 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// checkSignature verifies the detached signature Options.SignatureFile of
+// the content of a manifest before it's trusted, aborting if it's invalid.
+// Signatures ending in .minisig are checked with minisign and others with
+// gpg, so neither is needed unless a signature is given. The content is
+// checked from a private copy, so the file can't be swapped after checking.
+func checkSignature(content []byte, manifest string, opts *Options) {
+	if manifest == "" {
+		manifest = "stdin"
+	}
+
+	file, err := ioutil.TempFile("", "gosfv-manifest-")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		log.Fatal(err)
+	}
+
+	var cmd *exec.Cmd
+	if strings.HasSuffix(opts.SignatureFile, ".minisig") {
+		args := []string{"-V", "-m", file.Name(), "-x", opts.SignatureFile}
+		if opts.SignatureKey != "" {
+			args = append(args, "-p", opts.SignatureKey)
+		}
+		cmd = exec.Command("minisign", args...)
+	} else {
+		args := []string{"--batch", "--verify"}
+		if opts.SignatureKey != "" {
+			args = append(args, "--no-default-keyring", "--keyring", opts.SignatureKey)
+		}
+		cmd = exec.Command("gpg", append(args, opts.SignatureFile, file.Name())...)
+	}
+
+	// Removed before any error, since exiting skips deferred calls
+	output, err := cmd.CombinedOutput()
+	os.Remove(file.Name())
+
+	if execErr, ok := err.(*exec.Error); ok {
+		log.Fatalf("%s: %s is needed to verify the signature: %v", opts.SignatureFile, cmd.Args[0], execErr.Err)
+	} else if err != nil {
+		log.Fatalf("%s: signature of %s is invalid: %s", opts.SignatureFile, manifest, strings.TrimSpace(string(output)))
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"regexp"
+	"strings"
+)
+
+// reIntegrityLine matches the lines of the sri format, a Subresource
+// Integrity string and a name
+var reIntegrityLine = regexp.MustCompile(`^((?:sha256|sha384|sha512)-[A-Za-z0-9+/]+=*)[\s]+([\w\pL\pM\pN\./-]+)$`)
+
+// integrityTypes are the checksum types Subresource Integrity allows
+var integrityTypes = []ChecksumType{TypeSHA256, TypeSHA384, TypeSHA512}
+
+// IsIntegrityType tells whether checksums of type t can be written as
+// Subresource Integrity strings
+func IsIntegrityType(t ChecksumType) bool {
+	for _, integrityType := range integrityTypes {
+		if t == integrityType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Integrity returns the Subresource Integrity string of a hex checksum of
+// type t, like sha384-<base64>, or an empty string for other types
+func Integrity(t ChecksumType, checksum string) string {
+	if !IsIntegrityType(t) {
+		return ""
+	}
+
+	return TypeToString(t) + "-" + encodeChecksum(checksum, EncodingBase64)
+}
+
+// parseIntegrity returns the type and hex checksum of a Subresource
+// Integrity string
+func parseIntegrity(integrity string) (ChecksumType, string, bool) {
+	i := strings.Index(integrity, "-")
+	if i < 0 {
+		return TypeUnknown, "", false
+	}
+
+	t := StringToType(integrity[:i])
+	if !IsIntegrityType(t) {
+		return TypeUnknown, "", false
+	}
+
+	checksum, ok := decodeChecksum(integrity[i+1:], EncodingBase64)
+	if !ok {
+		return TypeUnknown, "", false
+	}
+
+	return t, checksum, true
+}
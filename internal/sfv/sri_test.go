@@ -0,0 +1,67 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIntegrityRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	template, err := ParseLineFormat("sri")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []Encoding{EncodingHex, EncodingBase64} {
+		for _, checksumType := range integrityTypes {
+			opts := DefaultOptions()
+			opts.BaseDir = "testdata"
+			opts.Encoding = encoding
+			opts.LineTemplate = template
+
+			name := TypeToString(checksumType)
+			manifest := filepath.Join(dir, name+".sri")
+			WriteToFileWithOptions(CreateWithOptions(checksumType, []string{"hello.txt"}, opts), manifest, opts)
+
+			verified := VerifyWithOptions(manifest, opts)
+			if len(verified) != 1 {
+				t.Errorf("%s: verified %d entries, want 1", name, len(verified))
+				continue
+			}
+			if verified[0].ChecksumType != checksumType || verified[0].Status != StatusCheckSumOK {
+				t.Errorf("%s: verified as %s with %s", name, TypeToString(verified[0].ChecksumType),
+					StatusTypeToString(verified[0].Status))
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// streamFlushInterval is how often streamed entries are flushed to the file
+const streamFlushInterval = time.Second
+
+// CreateToFileWithOptions works like CreateWithOptions followed by
+// WriteToFileWithOptions, but writes each entry as soon as it's hashed
+// instead of when every file is done. An interrupted run leaves the entries
+// hashed so far in the file.
+func CreateToFileWithOptions(t ChecksumType, files []string, filename string, opts Options) []ChecksumFile {
+	writer, err := newStreamWriter(filename, &opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	checksumFiles := create(t, files, &opts, func(checksumFile ChecksumFile) {
+		if err == nil {
+			err = writer.write(checksumFile)
+		}
+	})
+
+	if closeErr := writer.close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return checksumFiles
+}
+
+// streamWriter writes entries one by one, since there are no entries to
+// collect the comments from the comments are written next to each entry
+type streamWriter struct {
+	file      *os.File
+	buf       *bufio.Writer
+	opts      *Options
+	out       checksumWriter
+	listed    map[string]bool
+	lastFlush time.Time
+
+	// written is the file as last flushed when appending, to notice other
+	// processes changing it
+	filename string
+	written  os.FileInfo
+
+	// hasher hashes what's written for Options.WriteManifestHash
+	hasher *manifestHasher
+}
+
+// newStreamWriter opens filename for streaming, standard output if empty
+func newStreamWriter(filename string, opts *Options) (*streamWriter, error) {
+	w := &streamWriter{file: os.Stdout, opts: opts, listed: make(map[string]bool), lastFlush: time.Now()}
+
+	header := opts.WriteHeader
+	if filename != "" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if opts.Append {
+			flags = os.O_CREATE | os.O_RDWR | os.O_APPEND
+			w.listed = listedFilenames(filename, opts)
+		}
+
+		file, err := os.OpenFile(filename, flags, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w.file = file
+
+		fileInfo, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		if fileInfo.Size() > 0 {
+			header = false
+		}
+
+		if opts.Append {
+			w.filename = filename
+			w.written = fileInfo
+		}
+	}
+
+	w.buf = bufio.NewWriter(w.file)
+	w.out = w.buf
+	if opts.WriteManifestHash {
+		w.hasher = &manifestHasher{w.buf, sha256.New()}
+		w.out = w.hasher
+
+		// The hash covers the lines already in a file appended to
+		if opts.Append && filename != "" {
+			if err := w.hasher.precede(w.file); err != nil {
+				w.hasher = nil
+				w.close()
+				return nil, err
+			}
+		}
+	}
+
+	if header {
+		if err := writeHeader(w.out, opts); err != nil {
+			w.hasher = nil
+			w.close()
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (w *streamWriter) write(checksumFile ChecksumFile) error {
+	if checksumFile.Status != StatusCheckSumOK {
+		return nil
+	}
+
+	if w.listed[checksumFile.Filename] {
+		warn("%s: already in the file, not appended again", checksumFile.Filename)
+		return nil
+	}
+
+	if w.opts.LineTemplate == nil {
+		if err := writeComments(w.out, checksumFile, w.opts); err != nil {
+			return err
+		}
+	}
+
+	if err := writeChecksumLine(w.out, checksumFile, w.opts); err != nil {
+		return err
+	}
+
+	if time.Since(w.lastFlush) >= streamFlushInterval {
+		w.lastFlush = time.Now()
+		return w.flush()
+	}
+
+	return nil
+}
+
+// flush writes the buffered entries, unless the file being appended to was
+// changed by someone else since the last flush
+func (w *streamWriter) flush() error {
+	if w.written == nil {
+		return w.buf.Flush()
+	}
+
+	if manifestChanged(w.filename, w.written) {
+		return fmt.Errorf("%s: manifest changed on disk", w.filename)
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+
+	written, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	w.written = written
+
+	return nil
+}
+
+func (w *streamWriter) close() error {
+	var err error
+	if w.hasher != nil {
+		err = w.hasher.writeHash()
+		w.hasher = nil
+	}
+
+	if flushErr := w.flush(); err == nil {
+		err = flushErr
+	}
+	if w.file != os.Stdout {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettleTime is how long a file must be left alone after a change
+// before it's verified again
+const watchSettleTime = 500 * time.Millisecond
+
+// Watch verifies files again when they change on disk, calling report with
+// the new result, until stop is closed.
+func Watch(checksumFiles []ChecksumFile, opts Options, report func(ChecksumFile), stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Files are often replaced rather than written to, so watch their
+	// directories instead of the files themselves
+	watched := make(map[string]int)
+	dirs := make(map[string]bool)
+	for i, checksumFile := range checksumFiles {
+		path, err := filepath.Abs(opts.path(checksumFile.Filename))
+		if err != nil {
+			return err
+		}
+		watched[path] = i
+
+		// A directory which can't be watched, like that of a missing file,
+		// leaves the files in other directories watched
+		dir := filepath.Dir(path)
+		if !dirs[dir] {
+			if err := watcher.Add(dir); err != nil {
+				warn("%s: can't watch for changes, skipped: %v", dir, err)
+			}
+			dirs[dir] = true
+		}
+	}
+
+	pending := make(map[int]bool)
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if i, ok := watched[event.Name]; ok {
+				pending[i] = true
+				settle = time.After(watchSettleTime)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-settle:
+			for i, _ := range pending {
+				recheckChecksumFile(&checksumFiles[i], &opts)
+				report(checksumFiles[i])
+			}
+
+			pending = make(map[int]bool)
+			settle = nil
+		}
+	}
+}
+
+// recheckChecksumFile verifies a changed entry again, with the same checks
+// as VerifyWithOptions
+func recheckChecksumFile(checksumFile *ChecksumFile, opts *Options) {
+	checksumFile.Status = StatusUnknown
+	checksumFile.Checksum = ""
+
+	verifyChecksumFile(checksumFile, opts)
+	checkFilesize(checksumFile)
+	calculateChecksum(checksumFile, newProgress([]ChecksumFile{*checksumFile}, opts), opts)
+	compareChecksum(checksumFile)
+
+	if opts.RehashOnMismatch {
+		rehashMismatch(checksumFile, opts)
+	}
+
+	checkMetadata(checksumFile)
+}
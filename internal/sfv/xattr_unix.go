@@ -0,0 +1,103 @@
+// +build darwin freebsd linux netbsd
+
+/*
+Copyright © 2021 Robin Helgelin
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package sfv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileXattrs returns a hash of the extended attribute names and values of a
+// file, or false if they can't be listed, like on filesystems without them
+func fileXattrs(path string) (string, bool) {
+	list, err := readXattr(func(dest []byte) (int, error) {
+		return unix.Listxattr(path, dest)
+	})
+	if err != nil {
+		return "", false
+	}
+
+	names := make([]string, 0)
+	for _, name := range strings.Split(string(list), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Lengths are hashed along with the values, so moving bytes from one
+	// attribute to the next changes the hash
+	h := sha256.New()
+	for _, name := range names {
+		value, err := readXattr(func(dest []byte) (int, error) {
+			return unix.Getxattr(path, name, dest)
+		})
+		if err != nil {
+			return "", false
+		}
+
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(value))
+		h.Write(value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// readXattr calls an xattr syscall first for the size of its result and then
+// for the result itself, retrying if it grew in between
+func readXattr(call func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+
+		dest := make([]byte, size)
+		size, err = call(dest)
+		if err == unix.ERANGE {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return dest[:size], nil
+	}
+}